@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/namsral/flag"
+)
+
+const deadLetterUsage = `Usage: %s deadletter <action> [flags] [id]
+
+Actions:
+  list               List dead-lettered deliveries
+  show <id>          Show a dead-lettered delivery with its headers and payload
+  replay <id>        Replay a dead-lettered delivery, to -upstream if it is set
+  purge <id> | -all  Purge one or all dead-lettered deliveries
+
+Flags:
+`
+
+// runDeadLetter manages the dead letters of a running proxy through its admin endpoints
+func runDeadLetter(args []string) error {
+	deadLetterFlags := flag.NewFlagSetWithEnvPrefix("deadletter", "GWP", flag.ExitOnError)
+	admin := deadLetterFlags.String("admin", "http://localhost:8081", "URL of the admin endpoints of the proxy")
+	upstream := deadLetterFlags.String("upstream", "", "Upstream URL to replay the delivery to instead of the original upstreams")
+	all := deadLetterFlags.Bool("all", false, "Purge all dead-lettered deliveries")
+	deadLetterFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, deadLetterUsage, os.Args[0])
+		deadLetterFlags.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		deadLetterFlags.Usage()
+		return errors.New("No dead letter action specified")
+	}
+	action := args[0]
+	deadLetterFlags.Parse(args[1:])
+	id := deadLetterFlags.Arg(0)
+
+	base := strings.TrimSuffix(*admin, "/") + "/deadletters"
+	switch {
+	case action == "list":
+		return adminRequest(http.MethodGet, base)
+	case action == "show" && len(id) > 0:
+		return adminRequest(http.MethodGet, base+"/"+url.PathEscape(id))
+	case action == "replay" && len(id) > 0:
+		replayURL := base + "/" + url.PathEscape(id) + "/replay"
+		if len(*upstream) > 0 {
+			replayURL += "?upstream=" + url.QueryEscape(*upstream)
+		}
+		return adminRequest(http.MethodPost, replayURL)
+	case action == "purge" && len(id) > 0:
+		return adminRequest(http.MethodDelete, base+"/"+url.PathEscape(id))
+	case action == "purge" && *all:
+		return adminRequest(http.MethodDelete, base)
+	}
+
+	deadLetterFlags.Usage()
+	return errors.New("Invalid dead letter action '" + action + "'")
+}
+
+// adminRequest sends a request to the admin endpoints and prints the response
+func adminRequest(method string, adminURL string) error {
+	req, err := http.NewRequest(method, adminURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(body))
+	if resp.StatusCode >= 400 {
+		return errors.New("Admin request failed with status " + resp.Status)
+	}
+	return nil
+}
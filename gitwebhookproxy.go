@@ -5,22 +5,74 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/namsral/flag"
 	"github.com/stakater/GitWebhookProxy/pkg/proxy"
 )
 
 var (
-	flagSet       = flag.NewFlagSetWithEnvPrefix(os.Args[0], "GWP", 0)
-	listenAddress = flagSet.String("listen", ":8080", "Address on which the proxy listens.")
-	upstreamURL   = flagSet.String("upstreamURL", "", "URL to which the proxy requests will be forwarded (required)")
-	secret        = flagSet.String("secret", "", "Secret of the Webhook API. If not set validation is not made.")
-	provider      = flagSet.String("provider", "github", "Git Provider which generates the Webhook")
-	allowedPaths  = flagSet.String("allowedPaths", "", "Comma-Separated String List of allowed paths")
-	ignoredUsers  = flagSet.String("ignoredUsers", "", "Comma-Separated String List of users to ignore while proxying Webhook request")
-	allowedUsers  = flagSet.String("allowedUser", "", "Comma-Separated String List of users to allow while proxying Webhook request")
+	flagSet                    = flag.NewFlagSetWithEnvPrefix(os.Args[0], "GWP", 0)
+	listenAddress              = flagSet.String("listen", ":8080", "Address on which the proxy listens.")
+	upstreamURL                = flagSet.String("upstreamURL", "", "URL to which the proxy requests will be forwarded (required)")
+	secret                     = flagSet.String("secret", "", "Secret of the Webhook API. If not set validation is not made.")
+	provider                   = flagSet.String("provider", "github", "Git Provider which generates the Webhook")
+	allowedPaths               = flagSet.String("allowedPaths", "", "Comma-Separated String List of allowed paths")
+	ignoredUsers               = flagSet.String("ignoredUsers", "", "Comma-Separated String List of users to ignore while proxying Webhook request")
+	allowedUsers               = flagSet.String("allowedUser", "", "Comma-Separated String List of users to allow while proxying Webhook request")
+	requiredLabels             = flagSet.String("requiredLabels", "", "Comma-Separated String List of labels a pull/merge request must carry to be proxied")
+	ignoredLabels              = flagSet.String("ignoredLabels", "", "Comma-Separated String List of labels for which pull/merge requests are not proxied")
+	forkPolicy                 = flagSet.String("forkPolicy", "allow", "Policy for untrusted pull/merge requests from forks: allow, block or hold")
+	forkAllowedAssociations    = flagSet.String("forkAllowedAssociations", "OWNER,MEMBER,COLLABORATOR", "Comma-Separated String List of author associations trusted to open pull requests from forks")
+	forkApprovalLabel          = flagSet.String("forkApprovalLabel", "", "Label which approves a pull/merge request from a fork to be proxied")
+	ignoreDrafts               = flagSet.Bool("ignoreDrafts", false, "Ignore events of draft pull/merge requests")
+	readyForReviewAction       = flagSet.String("readyForReviewAction", "", "Action which replaces the action of a draft pull/merge request marked as ready for review, e.g. opened")
+	allowedRepositories        = flagSet.String("allowedRepositories", "", "Comma-Separated String List of repository full names or globs to allow, e.g. platform/*")
+	deniedRepositories         = flagSet.String("deniedRepositories", "", "Comma-Separated String List of repository full names or globs to deny")
+	ignoreBots                 = flagSet.Bool("ignoreBots", false, "Ignore Webhook requests sent by bots")
+	async                      = flagSet.Bool("async", false, "Acknowledge Webhook requests immediately and deliver them to the upstream in the background")
+	workers                    = flagSet.Int("workers", 4, "Number of workers delivering Webhook requests in async mode")
+	queueSize                  = flagSet.Int("queueSize", 100, "Number of Webhook requests waiting for delivery in async mode")
+	maxRetries                 = flagSet.Int("maxRetries", 0, "Number of retries of upstream requests failing with a network error or a 502, 503, 504 or 429 status")
+	retryInitialInterval       = flagSet.Duration("retryInitialInterval", time.Second, "Interval before the first retry, doubled for every further retry")
+	retryMaxInterval           = flagSet.Duration("retryMaxInterval", 30*time.Second, "Maximum interval between retries")
+	retryMaxElapsedTime        = flagSet.Duration("retryMaxElapsedTime", 2*time.Minute, "Maximum time spent retrying an upstream request")
+	queueDir                   = flagSet.String("queueDir", "", "Directory in which Webhook requests are stored until they are delivered in async mode")
+	circuitBreakerThreshold    = flagSet.Int("circuitBreakerThreshold", 0, "Number of consecutive failures of an upstream after which its circuit breaker opens. Disabled if 0.")
+	circuitBreakerOpenDuration = flagSet.Duration("circuitBreakerOpenDuration", 30*time.Second, "Time an open circuit breaker fails requests before probing the upstream again")
+	upstreamMaxConcurrent      = flagSet.Int("upstreamMaxConcurrent", 0, "Maximum number of requests in flight to each upstream host. Unlimited if 0.")
+	upstreamRateLimit          = flagSet.Float64("upstreamRateLimit", 0, "Maximum number of requests per second to each upstream host. Unlimited if 0.")
+	upstreamRateBurst          = flagSet.Int("upstreamRateBurst", 1, "Number of requests sent to an upstream host in a burst above upstreamRateLimit")
+	upstreamLimitPolicy        = flagSet.String("upstreamLimitPolicy", "queue", "Policy for requests exceeding the upstream limits: queue or reject")
+	upstreamLimitMaxWait       = flagSet.Duration("upstreamLimitMaxWait", 10*time.Second, "Maximum time a request waits for the upstream limits with the queue policy")
+	debounceWindow             = flagSet.Duration("debounceWindow", 0, "Time push events to a repository and ref are held so only the latest is forwarded. Disabled if 0.")
+	ordering                   = flagSet.String("ordering", "", "Deliver Webhook requests of the same repository or ref one at a time in arrival order: repository or ref")
+	responseHeaders            = flagSet.String("responseHeaders", "", "Comma-Separated String List of upstream response headers or globs passed through to the Git provider")
+	deniedResponseHeaders      = flagSet.String("deniedResponseHeaders", "", "Comma-Separated String List of upstream response headers or globs never passed through to the Git provider")
+	hideUpstreamBody           = flagSet.Bool("hideUpstreamBody", false, "Do not echo the body of the upstream response to the Git provider")
+	maxPayloadSize             = flagSet.Int64("maxPayloadSize", 25*1024*1024, "Maximum size in bytes of Webhook payloads, larger payloads are rejected with 413. Unlimited if 0.")
+	upstreamCAFile             = flagSet.String("upstreamCAFile", "", "Path to a PEM bundle of CA certificates trusted for upstreams in addition to the system ones")
+	upstreamCertFile           = flagSet.String("upstreamCertFile", "", "Path to a PEM client certificate presented to upstreams requiring mutual TLS")
+	upstreamKeyFile            = flagSet.String("upstreamKeyFile", "", "Path to the PEM key of upstreamCertFile")
+	insecureUpstreamHosts      = flagSet.String("insecureUpstreamHosts", "", "Comma-Separated String List of upstream hosts or globs whose TLS certificates are not verified")
+	upstreamSecret             = flagSet.String("upstreamSecret", "", "Secret with which Webhook requests are signed again before they are sent to the upstream. If not set the provider's signature is forwarded.")
+	forwardHeaders             = flagSet.String("forwardHeaders", "", "Comma-Separated String List of Git provider request headers or globs forwarded to the upstream in addition to the provider's headers, * forwards all")
+	dropHeaders                = flagSet.String("dropHeaders", "", "Comma-Separated String List of Git provider request headers or globs never forwarded to the upstream")
+	addHeaders                 = flagSet.String("addHeaders", "", "Comma-Separated String List of static 'Name:value' headers set on every upstream request")
+	forwardedHeaders           = flagSet.Bool("forwardedHeaders", false, "Add X-Forwarded-For, X-Forwarded-Proto and Forwarded headers to upstream requests")
+	deadLetterDir              = flagSet.String("deadLetterDir", "", "Directory in which Webhook requests are stored when their delivery failed after all retries")
+	adminListen                = flagSet.String("adminListen", "", "Address on which the admin endpoints, e.g. to manage dead letters, are served. Disabled if not set.")
+	configFile                 = flagSet.String("config", "", "Path to a JSON file with additional configuration e.g. comment commands")
 )
 
+// splitList splits a Comma-Separated list into an array
+func splitList(list string) []string {
+	if len(list) == 0 {
+		return []string{}
+	}
+	return strings.Split(list, ",")
+}
+
 func validateRequiredFlags() {
 	isValid := true
 	if len(strings.TrimSpace(*upstreamURL)) == 0 {
@@ -39,24 +91,95 @@ func validateRequiredFlags() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "deadletter" {
+		if err := runDeadLetter(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	flagSet.Parse(os.Args[1:])
 	validateRequiredFlags()
 	lowerProvider := strings.ToLower(*provider)
 
-	// Split Comma-Separated list into an array
-	allowedPathsArray := []string{}
-	if len(*allowedPaths) > 0 {
-		allowedPathsArray = strings.Split(*allowedPaths, ",")
+	allowedPathsArray := splitList(*allowedPaths)
+	ignoredUsersArray := splitList(*ignoredUsers)
+
+	options := []proxy.Option{
+		proxy.WithIgnoreBots(*ignoreBots),
+		proxy.WithRequiredLabels(splitList(*requiredLabels)),
+		proxy.WithIgnoredLabels(splitList(*ignoredLabels)),
+		proxy.WithForkPolicy(strings.ToLower(*forkPolicy), splitList(*forkAllowedAssociations), *forkApprovalLabel),
+		proxy.WithDraftPolicy(*ignoreDrafts, *readyForReviewAction),
+		proxy.WithRepositories(splitList(*allowedRepositories), splitList(*deniedRepositories)),
+		proxy.WithDebounce(*debounceWindow),
+		proxy.WithOrdering(strings.ToLower(*ordering)),
+		proxy.WithRetries(*maxRetries, *retryInitialInterval, *retryMaxInterval, *retryMaxElapsedTime),
+		proxy.WithCircuitBreaker(*circuitBreakerThreshold, *circuitBreakerOpenDuration),
+		proxy.WithUpstreamLimits(*upstreamMaxConcurrent, *upstreamRateLimit, *upstreamRateBurst,
+			strings.ToLower(*upstreamLimitPolicy), *upstreamLimitMaxWait),
+		proxy.WithMaxPayloadSize(*maxPayloadSize),
+		proxy.WithResponseHeaders(splitList(*responseHeaders), splitList(*deniedResponseHeaders)),
+		proxy.WithHideUpstreamBody(*hideUpstreamBody),
+		proxy.WithDeadLetterDir(*deadLetterDir),
+		proxy.WithAdminListenAddress(*adminListen),
+	}
+
+	if *async {
+		options = append(options, proxy.WithAsyncDelivery(*workers, *queueSize), proxy.WithQueueDir(*queueDir))
+	}
+
+	headerPolicy := proxy.HeaderPolicy{
+		Forward:   splitList(*forwardHeaders),
+		Drop:      splitList(*dropHeaders),
+		Add:       map[string]string{},
+		Forwarded: *forwardedHeaders,
+	}
+	for _, header := range splitList(*addHeaders) {
+		nameValue := strings.SplitN(header, ":", 2)
+		if len(nameValue) != 2 {
+			log.Fatalf("Invalid header '%s' in addHeaders, expected 'Name:value'", header)
+		}
+		headerPolicy.Add[strings.TrimSpace(nameValue[0])] = strings.TrimSpace(nameValue[1])
+	}
+
+	upstreamTLS := []proxy.UpstreamTLS{}
+	upstreamSecrets := []proxy.UpstreamSecret{}
+	if len(*configFile) > 0 {
+		config, err := proxy.LoadConfig(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, proxy.WithCommands(config.Commands), proxy.WithRoutes(config.Routes, config.AllowedUpstreamHosts))
+		upstreamTLS = append(upstreamTLS, config.UpstreamTLS...)
+		upstreamSecrets = append(upstreamSecrets, config.UpstreamSecrets...)
+		options = append(options, proxy.WithUpstreamAuth(config.UpstreamAuth))
+		headerPolicy.Forward = append(headerPolicy.Forward, config.Headers.Forward...)
+		headerPolicy.Drop = append(headerPolicy.Drop, config.Headers.Drop...)
+		for name, value := range config.Headers.Add {
+			headerPolicy.Add[name] = value
+		}
+		headerPolicy.Forwarded = headerPolicy.Forwarded || config.Headers.Forwarded
+	}
+	if len(*upstreamSecret) > 0 {
+		upstreamSecrets = append(upstreamSecrets, proxy.UpstreamSecret{Secret: *upstreamSecret})
 	}
+	options = append(options, proxy.WithUpstreamSecrets(upstreamSecrets))
+	options = append(options, proxy.WithHeaderPolicy(headerPolicy))
 
-	// Split Comma-Separated list into an array
-	ignoredUsersArray := []string{}
-	if len(*ignoredUsers) > 0 {
-		ignoredUsersArray = strings.Split(*ignoredUsers, ",")
+	// The flags apply to upstreams without a TLS configuration in the config file
+	defaultTLS := proxy.UpstreamTLS{CAFile: *upstreamCAFile, CertFile: *upstreamCertFile, KeyFile: *upstreamKeyFile}
+	if len(*insecureUpstreamHosts) > 0 {
+		insecureTLS := defaultTLS
+		insecureTLS.Hosts = splitList(*insecureUpstreamHosts)
+		insecureTLS.InsecureSkipVerify = true
+		upstreamTLS = append(upstreamTLS, insecureTLS)
 	}
+	upstreamTLS = append(upstreamTLS, defaultTLS)
+	options = append(options, proxy.WithUpstreamTLS(upstreamTLS))
 
 	log.Printf("Stakater Git WebHook Proxy started with provider '%s'\n", lowerProvider)
-	p, err := proxy.NewProxy(*upstreamURL, allowedPathsArray, lowerProvider, *secret, ignoredUsersArray)
+	p, err := proxy.NewProxy(*upstreamURL, allowedPathsArray, lowerProvider, *secret, ignoredUsersArray, options...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1,14 +1,25 @@
 package parser
 
 import (
+	"bytes"
 	"errors"
-	"io/ioutil"
+	"io"
 	"net/http"
 
 	"github.com/stakater/GitWebhookProxy/pkg/providers"
 )
 
+// ErrPayloadTooLarge is returned when the request body exceeds the maximum payload size
+var ErrPayloadTooLarge = errors.New("Payload exceeds the maximum size")
+
 func Parse(req *http.Request, provider providers.Provider) (*providers.Hook, error) {
+	return ParseWithLimit(req, provider, 0)
+}
+
+// ParseWithLimit parses the request like Parse, failing with ErrPayloadTooLarge
+// if the body is larger than maxPayloadSize bytes. There is no limit if
+// maxPayloadSize is 0.
+func ParseWithLimit(req *http.Request, provider providers.Provider, maxPayloadSize int64) (*providers.Hook, error) {
 	hook := &providers.Hook{
 		Headers: make(map[string]string),
 	}
@@ -21,7 +32,7 @@ func Parse(req *http.Request, provider providers.Provider) (*providers.Hook, err
 		return nil, errors.New("Required header '" + header + "' not found in Request")
 	}
 
-	if body, err := ioutil.ReadAll(req.Body); err != nil {
+	if body, err := readPayload(req, maxPayloadSize); err != nil {
 		return nil, err
 	} else {
 		hook.Payload = body
@@ -31,3 +42,36 @@ func Parse(req *http.Request, provider providers.Provider) (*providers.Hook, err
 
 	return hook, nil
 }
+
+// preallocatedPayloadSize caps the buffer grown up front from the client's
+// Content-Length, which is not trusted before the body is actually read
+const preallocatedPayloadSize = 64 * 1024
+
+// readPayload reads the request body, failing with ErrPayloadTooLarge once
+// more than maxPayloadSize bytes are read
+func readPayload(req *http.Request, maxPayloadSize int64) ([]byte, error) {
+	if maxPayloadSize > 0 && req.ContentLength > maxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+
+	var payload bytes.Buffer
+	if req.ContentLength > 0 {
+		size := req.ContentLength
+		if size > preallocatedPayloadSize {
+			size = preallocatedPayloadSize
+		}
+		payload.Grow(int(size))
+	}
+
+	body := io.Reader(req.Body)
+	if maxPayloadSize > 0 {
+		body = io.LimitReader(req.Body, maxPayloadSize+1)
+	}
+	if _, err := payload.ReadFrom(body); err != nil {
+		return nil, err
+	}
+	if maxPayloadSize > 0 && int64(payload.Len()) > maxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	return payload.Bytes(), nil
+}
@@ -129,3 +129,60 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestParseWithLimit(t *testing.T) {
+	chunkedRequest := createGitlabRequest(http.MethodPost, "/dummy", parserGitlabTestSecret,
+		parserGitlabTestEvent, parserGitlabTestBody)
+	chunkedRequest.ContentLength = -1
+	// The declared length is not trusted, only the bytes actually sent are read
+	hugeLengthRequest := createGitlabRequest(http.MethodPost, "/dummy", parserGitlabTestSecret,
+		parserGitlabTestEvent, parserGitlabTestBody)
+	hugeLengthRequest.ContentLength = 1 << 50
+
+	tests := []struct {
+		name           string
+		req            *http.Request
+		maxPayloadSize int64
+		want           *providers.Hook
+		wantErr        error
+	}{
+		{
+			name: "TestParseWithLimitWithPayloadWithinLimit",
+			req: createGitlabRequest(http.MethodPost, "/dummy", parserGitlabTestSecret,
+				parserGitlabTestEvent, parserGitlabTestBody),
+			maxPayloadSize: int64(len(parserGitlabTestBody)),
+			want:           createGitlabHook(parserGitlabTestSecret, parserGitlabTestEvent, parserGitlabTestBody, http.MethodPost),
+		},
+		{
+			name: "TestParseWithLimitWithPayloadTooLarge",
+			req: createGitlabRequest(http.MethodPost, "/dummy", parserGitlabTestSecret,
+				parserGitlabTestEvent, parserGitlabTestBody),
+			maxPayloadSize: 4,
+			wantErr:        ErrPayloadTooLarge,
+		},
+		{
+			name:           "TestParseWithLimitWithChunkedPayloadTooLarge",
+			req:            chunkedRequest,
+			maxPayloadSize: 4,
+			wantErr:        ErrPayloadTooLarge,
+		},
+		{
+			name:           "TestParseWithLimitWithoutLimitWithHugeContentLength",
+			req:            hugeLengthRequest,
+			maxPayloadSize: 0,
+			want:           createGitlabHook(parserGitlabTestSecret, parserGitlabTestEvent, parserGitlabTestBody, http.MethodPost),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseWithLimit(tt.req, createGitlabProvider(parserGitlabTestSecret), tt.maxPayloadSize)
+			if err != tt.wantErr {
+				t.Errorf("ParseWithLimit() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWithLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package providers
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -17,15 +18,20 @@ const (
 
 // Header constants
 const (
-	XHubSignature   = "X-Hub-Signature"
-	XGitHubEvent    = "X-GitHub-Event"
-	XGitHubDelivery = "X-GitHub-Delivery"
+	XHubSignature    = "X-Hub-Signature"
+	XHubSignature256 = "X-Hub-Signature-256"
+	XGitHubEvent     = "X-GitHub-Event"
+	XGitHubDelivery  = "X-GitHub-Delivery"
 )
 
+// GithubBotType is the sender type of Github Apps and bot accounts
+const GithubBotType = "Bot"
+
 const (
-	SignaturePrefix = "sha1="
-	SignatureLength = 45
-	GithubName      = "github"
+	SignaturePrefix    = "sha1="
+	SignatureLength    = 45
+	Signature256Prefix = "sha256="
+	GithubName         = "github"
 )
 
 type GithubProvider struct {
@@ -100,10 +106,134 @@ func (p *GithubProvider) GetCommitter(hook Hook) string {
 		return issueCommentPayloadData.Comment.User.Login
 	}
 
-	log.Printf("Event type is not supported: %v", eventType)
+	// All other events, e.g. release or deployment, are sent by their sender
+	var payloadData struct {
+		Sender struct {
+			Login string `json:"login"`
+		} `json:"sender"`
+	}
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Github payload unmarshaling failed for %v event: %v", eventType, err)
+		return ""
+	}
+	return payloadData.Sender.Login
+}
+
+func (p *GithubProvider) GetPullRequest(hook Hook) *PullRequest {
+	if Event(hook.Headers[XGitHubEvent]) != GithubPullRequestEvent {
+		return nil
+	}
+
+	var payloadData GithubPullRequestPayload
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Github payload unmarshaling failed for Pull Request event: %v", err)
+		return nil
+	}
+
+	pullRequest := &PullRequest{
+		Fork:              payloadData.PullRequest.Head.Repo.FullName != payloadData.PullRequest.Base.Repo.FullName,
+		AuthorAssociation: payloadData.PullRequest.AuthorAssociation,
+		Draft:             payloadData.PullRequest.Draft,
+		Action:            payloadData.Action,
+	}
+	for _, label := range payloadData.PullRequest.Labels {
+		pullRequest.Labels = append(pullRequest.Labels, label.Name)
+	}
+	return pullRequest
+}
+
+// SetPullRequestAction replaces the action of a pull request event's payload
+func (p *GithubProvider) SetPullRequestAction(hook *Hook, action string) error {
+	payload, err := setJSONField(hook.Payload, "action", action)
+	if err != nil {
+		return err
+	}
+	hook.Payload = payload
+	return nil
+}
+
+// GetRepository returns the full name of the repository, e.g. stakater/GitWebhookProxy
+func (p *GithubProvider) GetRepository(hook Hook) string {
+	var payloadData struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Github payload unmarshaling failed for repository: %v", err)
+		return ""
+	}
+	return payloadData.Repository.FullName
+}
+
+// IsBotSender checks whether the sender of the hook is a bot, e.g. dependabot[bot]
+func (p *GithubProvider) IsBotSender(hook Hook) bool {
+	var payloadData struct {
+		Sender struct {
+			Login string `json:"login"`
+			Type  string `json:"type"`
+		} `json:"sender"`
+	}
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Github payload unmarshaling failed for sender: %v", err)
+		return false
+	}
+	return payloadData.Sender.Type == GithubBotType || strings.HasSuffix(payloadData.Sender.Login, "[bot]")
+}
+
+func (p *GithubProvider) GetEvent(hook Hook) Event {
+	return Event(hook.Headers[XGitHubEvent])
+}
+
+// GetRef returns the pushed ref of push events and the base ref of pull
+// request events, e.g. refs/heads/master
+func (p *GithubProvider) GetRef(hook Hook) string {
+	var payloadData struct {
+		Ref         string `json:"ref"`
+		PullRequest struct {
+			Base struct {
+				Ref string `json:"ref"`
+			} `json:"base"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Github payload unmarshaling failed for ref: %v", err)
+		return ""
+	}
+
+	switch p.GetEvent(hook) {
+	case GithubPushEvent:
+		return payloadData.Ref
+	case GithubPullRequestEvent:
+		return "refs/heads/" + payloadData.PullRequest.Base.Ref
+	}
 	return ""
 }
 
+// GetComment returns the comment of an issue comment event. Edited and deleted
+// comments are not returned.
+func (p *GithubProvider) GetComment(hook Hook) *Comment {
+	if Event(hook.Headers[XGitHubEvent]) != GithubIssueCommentEvent {
+		return nil
+	}
+
+	var payloadData GithubIssueCommentPayload
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Github payload unmarshaling failed for issue comment event: %v", err)
+		return nil
+	}
+
+	if payloadData.Action != "created" {
+		return nil
+	}
+
+	return &Comment{
+		Body:              payloadData.Comment.Body,
+		Author:            payloadData.Comment.User.Login,
+		AuthorAssociation: payloadData.Comment.AuthorAssociation,
+	}
+}
+
 // IsValidPayload checks if the github payload's hash fits with
 // the hash computed by GitHub sent as a header
 func IsValidPayload(secret, headerHash string, payload []byte) bool {
@@ -124,3 +254,44 @@ func HashPayload(secret string, playloadBody []byte) string {
 	sum := hm.Sum(nil)
 	return fmt.Sprintf("%x", sum)
 }
+
+// HashPayload256 computes the SHA-256 hash of payload's body like HashPayload
+func HashPayload256(secret string, playloadBody []byte) string {
+	hm := hmac.New(sha256.New, []byte(secret))
+	hm.Write(playloadBody)
+	sum := hm.Sum(nil)
+	return fmt.Sprintf("%x", sum)
+}
+
+// Sign replaces the SHA-1 and SHA-256 signatures of the hook with ones computed with secret
+func (p *GithubProvider) Sign(hook *Hook, secret string) {
+	hook.Headers[XHubSignature] = SignaturePrefix + HashPayload(secret, hook.Payload)
+	hook.Headers[XHubSignature256] = Signature256Prefix + HashPayload256(secret, hook.Payload)
+}
+
+// GetPush returns the before and after SHA of push events
+func (p *GithubProvider) GetPush(hook Hook) *Push {
+	if p.GetEvent(hook) != GithubPushEvent {
+		return nil
+	}
+
+	var payloadData struct {
+		Before string `json:"before"`
+		After  string `json:"after"`
+	}
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Github payload unmarshaling failed for push: %v", err)
+		return nil
+	}
+	return &Push{Before: payloadData.Before, After: payloadData.After}
+}
+
+// SetPushBefore replaces the before SHA of a push event's payload
+func (p *GithubProvider) SetPushBefore(hook *Hook, before string) error {
+	payload, err := setJSONField(hook.Payload, "before", before)
+	if err != nil {
+		return err
+	}
+	hook.Payload = payload
+	return nil
+}
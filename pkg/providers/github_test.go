@@ -246,3 +246,389 @@ func TestGithubProvider_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestGithubProvider_GetPullRequest(t *testing.T) {
+	type args struct {
+		hook Hook
+	}
+	tests := []struct {
+		name string
+		args args
+		want *PullRequest
+	}{
+		{
+			name: "TestGetPullRequestWithLabels",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitHubEvent: string(GithubPullRequestEvent),
+					},
+					Payload: []byte(`{"pull_request": {"labels": [{"name": "ok-to-test"}, {"name": "bug"}]}}`),
+				},
+			},
+			want: &PullRequest{
+				Labels: []string{"ok-to-test", "bug"},
+			},
+		},
+		{
+			name: "TestGetPullRequestFromFork",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitHubEvent: string(GithubPullRequestEvent),
+					},
+					Payload: []byte(`{"pull_request": {"author_association": "CONTRIBUTOR",
+						"head": {"repo": {"full_name": "someone/GitWebhookProxy"}},
+						"base": {"repo": {"full_name": "stakater/GitWebhookProxy"}}}}`),
+				},
+			},
+			want: &PullRequest{
+				Fork:              true,
+				AuthorAssociation: "CONTRIBUTOR",
+			},
+		},
+		{
+			name: "TestGetPullRequestWithDraft",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitHubEvent: string(GithubPullRequestEvent),
+					},
+					Payload: []byte(`{"action": "synchronize", "pull_request": {"draft": true}}`),
+				},
+			},
+			want: &PullRequest{
+				Draft:  true,
+				Action: "synchronize",
+			},
+		},
+		{
+			name: "TestGetPullRequestWithPushEvent",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitHubEvent: string(GithubPushEvent),
+					},
+					Payload: []byte(`{"ref": "refs/heads/master"}`),
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "TestGetPullRequestWithInvalidPayload",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitHubEvent: string(GithubPullRequestEvent),
+					},
+					Payload: []byte(`invalid`),
+				},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GithubProvider{}
+			if got := p.GetPullRequest(tt.args.hook); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GithubProvider.GetPullRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubProvider_GetComment(t *testing.T) {
+	type args struct {
+		hook Hook
+	}
+	tests := []struct {
+		name string
+		args args
+		want *Comment
+	}{
+		{
+			name: "TestGetCommentWithCreatedComment",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitHubEvent: string(GithubIssueCommentEvent),
+					},
+					Payload: []byte(`{"action": "created", "comment": {"body": "/retest",
+						"author_association": "MEMBER", "user": {"login": "someuser"}}}`),
+				},
+			},
+			want: &Comment{
+				Body:              "/retest",
+				Author:            "someuser",
+				AuthorAssociation: "MEMBER",
+			},
+		},
+		{
+			name: "TestGetCommentWithEditedComment",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitHubEvent: string(GithubIssueCommentEvent),
+					},
+					Payload: []byte(`{"action": "edited", "comment": {"body": "/retest"}}`),
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "TestGetCommentWithPushEvent",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitHubEvent: string(GithubPushEvent),
+					},
+					Payload: []byte(`{}`),
+				},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GithubProvider{}
+			if got := p.GetComment(tt.args.hook); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GithubProvider.GetComment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubProvider_SetPullRequestAction(t *testing.T) {
+	p := &GithubProvider{}
+	hook := &Hook{
+		Headers: map[string]string{
+			XGitHubEvent: string(GithubPullRequestEvent),
+		},
+		Payload: []byte(`{"action": "ready_for_review", "number": 12345678901, "pull_request": {"draft": false}}`),
+	}
+
+	if err := p.SetPullRequestAction(hook, "opened"); err != nil {
+		t.Fatalf("GithubProvider.SetPullRequestAction() error = %v", err)
+	}
+
+	want := `{"action":"opened","number":12345678901,"pull_request":{"draft":false}}`
+	if string(hook.Payload) != want {
+		t.Errorf("GithubProvider.SetPullRequestAction() payload = %s, want %s", hook.Payload, want)
+	}
+}
+
+func TestGithubProvider_GetRepository(t *testing.T) {
+	p := &GithubProvider{}
+	hook := Hook{
+		Headers: map[string]string{
+			XGitHubEvent: string(GithubPushEvent),
+		},
+		Payload: []byte(`{"ref": "refs/heads/master", "repository": {"full_name": "stakater/GitWebhookProxy"}}`),
+	}
+
+	if got := p.GetRepository(hook); got != "stakater/GitWebhookProxy" {
+		t.Errorf("GithubProvider.GetRepository() = %v, want %v", got, "stakater/GitWebhookProxy")
+	}
+}
+
+func TestGithubProvider_GetCommitter(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   Event
+		payload string
+		want    string
+	}{
+		{
+			name:    "TestGetCommitterWithPushEvent",
+			event:   GithubPushEvent,
+			payload: `{"sender": {"login": "pusher"}}`,
+			want:    "pusher",
+		},
+		{
+			name:    "TestGetCommitterWithIssueCommentEvent",
+			event:   GithubIssueCommentEvent,
+			payload: `{"comment": {"user": {"login": "commenter"}}, "sender": {"login": "sender"}}`,
+			want:    "commenter",
+		},
+		{
+			name:    "TestGetCommitterWithReleaseEvent",
+			event:   "release",
+			payload: `{"action": "published", "sender": {"login": "releaser"}}`,
+			want:    "releaser",
+		},
+		{
+			name:    "TestGetCommitterWithoutSender",
+			event:   "deployment",
+			payload: `{"action": "created"}`,
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GithubProvider{}
+			hook := Hook{
+				Headers: map[string]string{
+					XGitHubEvent: string(tt.event),
+				},
+				Payload: []byte(tt.payload),
+			}
+			if got := p.GetCommitter(hook); got != tt.want {
+				t.Errorf("GithubProvider.GetCommitter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubProvider_IsBotSender(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    bool
+	}{
+		{
+			name:    "TestIsBotSenderWithBotType",
+			payload: `{"sender": {"login": "some-app", "type": "Bot"}}`,
+			want:    true,
+		},
+		{
+			name:    "TestIsBotSenderWithBotSuffix",
+			payload: `{"sender": {"login": "dependabot[bot]", "type": "User"}}`,
+			want:    true,
+		},
+		{
+			name:    "TestIsBotSenderWithUser",
+			payload: `{"sender": {"login": "someuser", "type": "User"}}`,
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GithubProvider{}
+			hook := Hook{
+				Headers: map[string]string{
+					XGitHubEvent: string(GithubPushEvent),
+				},
+				Payload: []byte(tt.payload),
+			}
+			if got := p.IsBotSender(hook); got != tt.want {
+				t.Errorf("GithubProvider.IsBotSender() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubProvider_GetRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   Event
+		payload string
+		want    string
+	}{
+		{
+			name:    "TestGetRefWithPushEvent",
+			event:   GithubPushEvent,
+			payload: `{"ref": "refs/tags/v1.0.0"}`,
+			want:    "refs/tags/v1.0.0",
+		},
+		{
+			name:    "TestGetRefWithPullRequestEvent",
+			event:   GithubPullRequestEvent,
+			payload: `{"pull_request": {"base": {"ref": "master"}}}`,
+			want:    "refs/heads/master",
+		},
+		{
+			name:    "TestGetRefWithIssueCommentEvent",
+			event:   GithubIssueCommentEvent,
+			payload: `{"action": "created"}`,
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GithubProvider{}
+			hook := Hook{
+				Headers: map[string]string{
+					XGitHubEvent: string(tt.event),
+				},
+				Payload: []byte(tt.payload),
+			}
+			if got := p.GetRef(hook); got != tt.want {
+				t.Errorf("GithubProvider.GetRef() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubProvider_GetPush(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   Event
+		payload string
+		want    *Push
+	}{
+		{
+			name:    "TestGetPushWithPushEvent",
+			event:   GithubPushEvent,
+			payload: `{"ref": "refs/heads/master", "before": "a1", "after": "b2"}`,
+			want:    &Push{Before: "a1", After: "b2"},
+		},
+		{
+			name:    "TestGetPushWithPullRequestEvent",
+			event:   GithubPullRequestEvent,
+			payload: `{"action": "opened", "before": "a1", "after": "b2"}`,
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GithubProvider{}
+			hook := Hook{
+				Headers: map[string]string{
+					XGitHubEvent: string(tt.event),
+				},
+				Payload: []byte(tt.payload),
+			}
+			if got := p.GetPush(hook); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GithubProvider.GetPush() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubProvider_SetPushBefore(t *testing.T) {
+	p := &GithubProvider{}
+	hook := &Hook{
+		Headers: map[string]string{
+			XGitHubEvent: string(GithubPushEvent),
+		},
+		Payload: []byte(`{"ref": "refs/heads/master", "before": "b2", "after": "c3"}`),
+	}
+
+	if err := p.SetPushBefore(hook, "a1"); err != nil {
+		t.Fatalf("GithubProvider.SetPushBefore() error = %v", err)
+	}
+
+	want := `{"after":"c3","before":"a1","ref":"refs/heads/master"}`
+	if string(hook.Payload) != want {
+		t.Errorf("GithubProvider.SetPushBefore() payload = %s, want %s", hook.Payload, want)
+	}
+}
+
+func TestGithubProvider_Sign(t *testing.T) {
+	p := &GithubProvider{}
+	hook := &Hook{
+		Headers: map[string]string{
+			XHubSignature: "sha1=" + HashPayload("proxySecret", []byte(`{"ref": "refs/heads/master"}`)),
+		},
+		Payload: []byte(`{"ref": "refs/heads/master"}`),
+	}
+
+	p.Sign(hook, "upstreamSecret")
+
+	if !IsValidPayload("upstreamSecret", hook.Headers[XHubSignature][len(SignaturePrefix):], hook.Payload) {
+		t.Errorf("GithubProvider.Sign() %s = %v, not signed with the upstream secret", XHubSignature, hook.Headers[XHubSignature])
+	}
+	if want := Signature256Prefix + HashPayload256("upstreamSecret", hook.Payload); hook.Headers[XHubSignature256] != want {
+		t.Errorf("GithubProvider.Sign() %s = %v, want %v", XHubSignature256, hook.Headers[XHubSignature256], want)
+	}
+}
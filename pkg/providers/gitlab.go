@@ -3,6 +3,7 @@ package providers
 import (
 	"encoding/json"
 	"log"
+	"regexp"
 	"strings"
 )
 
@@ -16,8 +17,13 @@ const (
 const (
 	GitlabPushEvent         Event = "Push Hook"
 	GitlabMergeRequestEvent Event = "Merge Request Hook"
+	GitlabNoteEvent         Event = "Note Hook"
+	GitlabTagPushEvent      Event = "Tag Push Hook"
 )
 
+// gitlabBotUsername matches the users Gitlab creates for project and group access tokens
+var gitlabBotUsername = regexp.MustCompile(`^(project|group)_\d+_bot(_[0-9a-f]+)?$`)
+
 type GitlabProvider struct {
 	secret string
 }
@@ -74,3 +80,168 @@ func (p *GitlabProvider) GetCommitter(hook Hook) string {
 	}
 	return ""
 }
+
+func (p *GitlabProvider) GetPullRequest(hook Hook) *PullRequest {
+	if Event(hook.Headers[XGitlabEvent]) != GitlabMergeRequestEvent {
+		return nil
+	}
+
+	var payloadData GitlabMergeRequestPayload
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Gitlab payload unmarshaling failed for Merge Request event: %v", err)
+		return nil
+	}
+
+	pullRequest := &PullRequest{
+		Fork:   payloadData.ObjectAttributes.SourceProjectID != payloadData.ObjectAttributes.TargetProjectID,
+		Draft:  payloadData.ObjectAttributes.Draft || payloadData.ObjectAttributes.WorkInProgress,
+		Action: payloadData.ObjectAttributes.Action,
+	}
+	// Gitlab has no separate action for marking a draft as ready, older
+	// versions report the change as work_in_progress instead of draft
+	draft, workInProgress := payloadData.Changes.Draft, payloadData.Changes.WorkInProgress
+	if (draft != nil && draft.Previous && !draft.Current) ||
+		(workInProgress != nil && workInProgress.Previous && !workInProgress.Current) {
+		pullRequest.Action = PullRequestReadyForReviewAction
+	}
+	for _, label := range payloadData.Labels {
+		pullRequest.Labels = append(pullRequest.Labels, label.Title)
+	}
+	return pullRequest
+}
+
+// SetPullRequestAction replaces the action in the object attributes of a merge request event's payload
+func (p *GitlabProvider) SetPullRequestAction(hook *Hook, action string) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(hook.Payload, &fields); err != nil {
+		return err
+	}
+
+	objectAttributes, err := setJSONField(fields["object_attributes"], "action", action)
+	if err != nil {
+		return err
+	}
+	fields["object_attributes"] = objectAttributes
+
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	hook.Payload = payload
+	return nil
+}
+
+// GetComment returns the note of a note event. System notes are not returned.
+func (p *GitlabProvider) GetComment(hook Hook) *Comment {
+	if Event(hook.Headers[XGitlabEvent]) != GitlabNoteEvent {
+		return nil
+	}
+
+	var payloadData GitlabNotePayload
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Gitlab payload unmarshaling failed for Note event: %v", err)
+		return nil
+	}
+
+	if payloadData.ObjectAttributes.System {
+		return nil
+	}
+
+	return &Comment{
+		Body:   payloadData.ObjectAttributes.Note,
+		Author: payloadData.User.Username,
+	}
+}
+
+// GetRepository returns the path with namespace of the project, e.g. platform/jenkins
+func (p *GitlabProvider) GetRepository(hook Hook) string {
+	var payloadData struct {
+		Project struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Gitlab payload unmarshaling failed for project: %v", err)
+		return ""
+	}
+	return payloadData.Project.PathWithNamespace
+}
+
+// IsBotSender checks whether the user of the hook is a bot, e.g. a project access token user
+func (p *GitlabProvider) IsBotSender(hook Hook) bool {
+	var payloadData struct {
+		Username string `json:"user_username"`
+		User     struct {
+			Username string `json:"username"`
+			Bot      bool   `json:"bot"`
+		} `json:"user"`
+	}
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Gitlab payload unmarshaling failed for user: %v", err)
+		return false
+	}
+	return payloadData.User.Bot ||
+		gitlabBotUsername.MatchString(payloadData.Username) ||
+		gitlabBotUsername.MatchString(payloadData.User.Username)
+}
+
+func (p *GitlabProvider) GetEvent(hook Hook) Event {
+	return Event(hook.Headers[XGitlabEvent])
+}
+
+// GetRef returns the pushed ref of push and tag push events and the target
+// ref of merge request events, e.g. refs/heads/master
+func (p *GitlabProvider) GetRef(hook Hook) string {
+	var payloadData struct {
+		Ref              string `json:"ref"`
+		ObjectAttributes struct {
+			TargetBranch string `json:"target_branch"`
+		} `json:"object_attributes"`
+	}
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Gitlab payload unmarshaling failed for ref: %v", err)
+		return ""
+	}
+
+	switch p.GetEvent(hook) {
+	case GitlabPushEvent, GitlabTagPushEvent:
+		return payloadData.Ref
+	case GitlabMergeRequestEvent:
+		return "refs/heads/" + payloadData.ObjectAttributes.TargetBranch
+	}
+	return ""
+}
+
+// GetPush returns the before and after SHA of push and tag push events
+func (p *GitlabProvider) GetPush(hook Hook) *Push {
+	switch p.GetEvent(hook) {
+	case GitlabPushEvent, GitlabTagPushEvent:
+	default:
+		return nil
+	}
+
+	var payloadData struct {
+		Before string `json:"before"`
+		After  string `json:"after"`
+	}
+	if err := json.Unmarshal(hook.Payload, &payloadData); err != nil {
+		log.Printf("Gitlab payload unmarshaling failed for push: %v", err)
+		return nil
+	}
+	return &Push{Before: payloadData.Before, After: payloadData.After}
+}
+
+// SetPushBefore replaces the before SHA of a push event's payload
+func (p *GitlabProvider) SetPushBefore(hook *Hook, before string) error {
+	payload, err := setJSONField(hook.Payload, "before", before)
+	if err != nil {
+		return err
+	}
+	hook.Payload = payload
+	return nil
+}
+
+// Sign replaces the token of the hook with secret
+func (p *GitlabProvider) Sign(hook *Hook, secret string) {
+	hook.Headers[XGitlabToken] = secret
+}
@@ -0,0 +1,83 @@
+package providers
+
+// GitlabMergeRequestPayload contains the information for Gitlab's merge request hook event
+type GitlabMergeRequestPayload struct {
+	ObjectKind string `json:"object_kind"`
+	User       struct {
+		Name      string `json:"name"`
+		Username  string `json:"username"`
+		AvatarURL string `json:"avatar_url"`
+	} `json:"user"`
+	Project struct {
+		ID                int64   `json:"id"`
+		Name              string  `json:"name"`
+		Description       string  `json:"description"`
+		WebURL            string  `json:"web_url"`
+		AvatarURL         *string `json:"avatar_url"`
+		GitSSHURL         string  `json:"git_ssh_url"`
+		GitHTTPURL        string  `json:"git_http_url"`
+		Namespace         string  `json:"namespace"`
+		VisibilityLevel   int64   `json:"visibility_level"`
+		PathWithNamespace string  `json:"path_with_namespace"`
+		DefaultBranch     string  `json:"default_branch"`
+		Homepage          string  `json:"homepage"`
+		URL               string  `json:"url"`
+		SSHURL            string  `json:"ssh_url"`
+		HTTPURL           string  `json:"http_url"`
+	} `json:"project"`
+	Repository struct {
+		Name        string `json:"name"`
+		URL         string `json:"url"`
+		Description string `json:"description"`
+		Homepage    string `json:"homepage"`
+	} `json:"repository"`
+	ObjectAttributes struct {
+		ID              int64  `json:"id"`
+		IID             int64  `json:"iid"`
+		TargetBranch    string `json:"target_branch"`
+		SourceBranch    string `json:"source_branch"`
+		SourceProjectID int64  `json:"source_project_id"`
+		AuthorID        int64  `json:"author_id"`
+		AssigneeID      int64  `json:"assignee_id"`
+		Title           string `json:"title"`
+		CreatedAt       string `json:"created_at"`
+		UpdatedAt       string `json:"updated_at"`
+		State           string `json:"state"`
+		MergeStatus     string `json:"merge_status"`
+		TargetProjectID int64  `json:"target_project_id"`
+		Description     string `json:"description"`
+		URL             string `json:"url"`
+		LastCommit      struct {
+			ID        string `json:"id"`
+			Message   string `json:"message"`
+			Timestamp string `json:"timestamp"`
+			URL       string `json:"url"`
+			Author    struct {
+				Name  string `json:"name"`
+				Email string `json:"email"`
+			} `json:"author"`
+		} `json:"last_commit"`
+		WorkInProgress bool   `json:"work_in_progress"`
+		Draft          bool   `json:"draft"`
+		Action         string `json:"action"`
+	} `json:"object_attributes"`
+	Changes struct {
+		Draft *struct {
+			Previous bool `json:"previous"`
+			Current  bool `json:"current"`
+		} `json:"draft"`
+		WorkInProgress *struct {
+			Previous bool `json:"previous"`
+			Current  bool `json:"current"`
+		} `json:"work_in_progress"`
+	} `json:"changes"`
+	Labels []struct {
+		ID          int64  `json:"id"`
+		Title       string `json:"title"`
+		Color       string `json:"color"`
+		ProjectID   int64  `json:"project_id"`
+		Description string `json:"description"`
+		Type        string `json:"type"`
+		GroupID     int64  `json:"group_id"`
+	} `json:"labels"`
+}
@@ -246,3 +246,337 @@ func TestGitlabProvider_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestGitlabProvider_GetPullRequest(t *testing.T) {
+	type args struct {
+		hook Hook
+	}
+	tests := []struct {
+		name string
+		args args
+		want *PullRequest
+	}{
+		{
+			name: "TestGetPullRequestWithLabels",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitlabEvent: string(GitlabMergeRequestEvent),
+					},
+					Payload: []byte(`{"object_kind": "merge_request", "labels": [{"title": "ok-to-test"}]}`),
+				},
+			},
+			want: &PullRequest{
+				Labels: []string{"ok-to-test"},
+			},
+		},
+		{
+			name: "TestGetPullRequestFromFork",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitlabEvent: string(GitlabMergeRequestEvent),
+					},
+					Payload: []byte(`{"object_kind": "merge_request",
+						"object_attributes": {"source_project_id": 15, "target_project_id": 14}}`),
+				},
+			},
+			want: &PullRequest{
+				Fork: true,
+			},
+		},
+		{
+			name: "TestGetPullRequestWithWorkInProgress",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitlabEvent: string(GitlabMergeRequestEvent),
+					},
+					Payload: []byte(`{"object_kind": "merge_request",
+						"object_attributes": {"action": "update", "work_in_progress": true}}`),
+				},
+			},
+			want: &PullRequest{
+				Draft:  true,
+				Action: "update",
+			},
+		},
+		{
+			name: "TestGetPullRequestMarkedAsReady",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitlabEvent: string(GitlabMergeRequestEvent),
+					},
+					Payload: []byte(`{"object_kind": "merge_request", "object_attributes": {"action": "update"},
+						"changes": {"draft": {"previous": true, "current": false}}}`),
+				},
+			},
+			want: &PullRequest{
+				Action: PullRequestReadyForReviewAction,
+			},
+		},
+		{
+			name: "TestGetPullRequestMarkedAsReadyWithWorkInProgress",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitlabEvent: string(GitlabMergeRequestEvent),
+					},
+					Payload: []byte(`{"object_kind": "merge_request", "object_attributes": {"action": "update"},
+						"changes": {"work_in_progress": {"previous": true, "current": false}}}`),
+				},
+			},
+			want: &PullRequest{
+				Action: PullRequestReadyForReviewAction,
+			},
+		},
+		{
+			name: "TestGetPullRequestWithPushEvent",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitlabEvent: string(GitlabPushEvent),
+					},
+					Payload: []byte(`{"object_kind": "push"}`),
+				},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GitlabProvider{}
+			if got := p.GetPullRequest(tt.args.hook); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GitlabProvider.GetPullRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitlabProvider_GetComment(t *testing.T) {
+	type args struct {
+		hook Hook
+	}
+	tests := []struct {
+		name string
+		args args
+		want *Comment
+	}{
+		{
+			name: "TestGetCommentWithNote",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitlabEvent: string(GitlabNoteEvent),
+					},
+					Payload: []byte(`{"object_kind": "note", "user": {"username": "jsmith"},
+						"object_attributes": {"note": "/deploy staging"}}`),
+				},
+			},
+			want: &Comment{
+				Body:   "/deploy staging",
+				Author: "jsmith",
+			},
+		},
+		{
+			name: "TestGetCommentWithSystemNote",
+			args: args{
+				hook: Hook{
+					Headers: map[string]string{
+						XGitlabEvent: string(GitlabNoteEvent),
+					},
+					Payload: []byte(`{"object_kind": "note", "object_attributes": {"note": "added 1 commit", "system": true}}`),
+				},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GitlabProvider{}
+			if got := p.GetComment(tt.args.hook); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GitlabProvider.GetComment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitlabProvider_SetPullRequestAction(t *testing.T) {
+	p := &GitlabProvider{}
+	hook := &Hook{
+		Headers: map[string]string{
+			XGitlabEvent: string(GitlabMergeRequestEvent),
+		},
+		Payload: []byte(`{"object_kind": "merge_request", "object_attributes": {"action": "update", "iid": 1}}`),
+	}
+
+	if err := p.SetPullRequestAction(hook, "open"); err != nil {
+		t.Fatalf("GitlabProvider.SetPullRequestAction() error = %v", err)
+	}
+
+	if pullRequest := p.GetPullRequest(*hook); pullRequest == nil || pullRequest.Action != "open" {
+		t.Errorf("GitlabProvider.SetPullRequestAction() got pull request %v, want action open", pullRequest)
+	}
+}
+
+func TestGitlabProvider_GetRepository(t *testing.T) {
+	p := &GitlabProvider{}
+	hook := Hook{
+		Headers: map[string]string{
+			XGitlabEvent: string(GitlabPushEvent),
+		},
+		Payload: []byte(`{"object_kind": "push", "project": {"path_with_namespace": "mike/diaspora"}}`),
+	}
+
+	if got := p.GetRepository(hook); got != "mike/diaspora" {
+		t.Errorf("GitlabProvider.GetRepository() = %v, want %v", got, "mike/diaspora")
+	}
+}
+
+func TestGitlabProvider_IsBotSender(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    bool
+	}{
+		{
+			name:    "TestIsBotSenderWithProjectBotPush",
+			payload: `{"object_kind": "push", "user_username": "project_15_bot_0123abcd"}`,
+			want:    true,
+		},
+		{
+			name:    "TestIsBotSenderWithBotFlag",
+			payload: `{"object_kind": "merge_request", "user": {"username": "renovate", "bot": true}}`,
+			want:    true,
+		},
+		{
+			name:    "TestIsBotSenderWithUser",
+			payload: `{"object_kind": "push", "user_username": "jsmith"}`,
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GitlabProvider{}
+			hook := Hook{
+				Payload: []byte(tt.payload),
+			}
+			if got := p.IsBotSender(hook); got != tt.want {
+				t.Errorf("GitlabProvider.IsBotSender() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitlabProvider_GetRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   Event
+		payload string
+		want    string
+	}{
+		{
+			name:    "TestGetRefWithPushEvent",
+			event:   GitlabPushEvent,
+			payload: `{"object_kind": "push", "ref": "refs/heads/master"}`,
+			want:    "refs/heads/master",
+		},
+		{
+			name:    "TestGetRefWithMergeRequestEvent",
+			event:   GitlabMergeRequestEvent,
+			payload: `{"object_kind": "merge_request", "object_attributes": {"target_branch": "develop"}}`,
+			want:    "refs/heads/develop",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GitlabProvider{}
+			hook := Hook{
+				Headers: map[string]string{
+					XGitlabEvent: string(tt.event),
+				},
+				Payload: []byte(tt.payload),
+			}
+			if got := p.GetRef(hook); got != tt.want {
+				t.Errorf("GitlabProvider.GetRef() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitlabProvider_GetPush(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   Event
+		payload string
+		want    *Push
+	}{
+		{
+			name:    "TestGetPushWithPushEvent",
+			event:   GitlabPushEvent,
+			payload: `{"object_kind": "push", "before": "a1", "after": "b2"}`,
+			want:    &Push{Before: "a1", After: "b2"},
+		},
+		{
+			name:    "TestGetPushWithTagPushEvent",
+			event:   GitlabTagPushEvent,
+			payload: `{"object_kind": "tag_push", "before": "0", "after": "b2"}`,
+			want:    &Push{Before: "0", After: "b2"},
+		},
+		{
+			name:    "TestGetPushWithMergeRequestEvent",
+			event:   GitlabMergeRequestEvent,
+			payload: `{"object_kind": "merge_request"}`,
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GitlabProvider{}
+			hook := Hook{
+				Headers: map[string]string{
+					XGitlabEvent: string(tt.event),
+				},
+				Payload: []byte(tt.payload),
+			}
+			if got := p.GetPush(hook); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GitlabProvider.GetPush() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitlabProvider_SetPushBefore(t *testing.T) {
+	p := &GitlabProvider{}
+	hook := &Hook{
+		Headers: map[string]string{
+			XGitlabEvent: string(GitlabPushEvent),
+		},
+		Payload: []byte(`{"object_kind": "push", "before": "b2", "after": "c3"}`),
+	}
+
+	if err := p.SetPushBefore(hook, "a1"); err != nil {
+		t.Fatalf("GitlabProvider.SetPushBefore() error = %v", err)
+	}
+
+	want := `{"after":"c3","before":"a1","object_kind":"push"}`
+	if string(hook.Payload) != want {
+		t.Errorf("GitlabProvider.SetPushBefore() payload = %s, want %s", hook.Payload, want)
+	}
+}
+
+func TestGitlabProvider_Sign(t *testing.T) {
+	p := &GitlabProvider{}
+	hook := &Hook{
+		Headers: map[string]string{
+			XGitlabToken: "proxySecret",
+		},
+	}
+
+	p.Sign(hook, "upstreamSecret")
+
+	if got := hook.Headers[XGitlabToken]; got != "upstreamSecret" {
+		t.Errorf("GitlabProvider.Sign() %s = %v, want %v", XGitlabToken, got, "upstreamSecret")
+	}
+}
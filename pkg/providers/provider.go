@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"encoding/json"
 	"errors"
 	"strings"
 )
@@ -12,6 +13,9 @@ const (
 	DefaultContentTypeHeaderValue = "application/json"
 )
 
+// PullRequestReadyForReviewAction is the action of a draft pull request marked as ready for review
+const PullRequestReadyForReviewAction = "ready_for_review"
+
 // Event defines a provider hook event type
 type Event string
 
@@ -20,6 +24,16 @@ type Provider interface {
 	Validate(hook Hook) bool
 	GetCommitter(hook Hook) string
 	GetProviderName() string
+	GetPullRequest(hook Hook) *PullRequest
+	GetComment(hook Hook) *Comment
+	SetPullRequestAction(hook *Hook, action string) error
+	GetRepository(hook Hook) string
+	IsBotSender(hook Hook) bool
+	GetEvent(hook Hook) Event
+	GetRef(hook Hook) string
+	GetPush(hook Hook) *Push
+	SetPushBefore(hook *Hook, before string) error
+	Sign(hook *Hook, secret string)
 }
 
 func assertProviderImplementations() {
@@ -47,3 +61,65 @@ type Hook struct {
 	Headers       map[string]string
 	RequestMethod string
 }
+
+// PullRequest is a provider independent view of a Github pull request or a
+// Gitlab merge request carried by a hook
+type PullRequest struct {
+	Labels []string
+	// Fork is true when the pull request comes from a different repository than its base
+	Fork bool
+	// AuthorAssociation is the author's relation to the base repository e.g. OWNER, MEMBER
+	AuthorAssociation string
+	Draft             bool
+	// Action is the provider's action of the hook, normalized to
+	// PullRequestReadyForReviewAction when a draft was marked as ready
+	Action string
+}
+
+// HasLabel reports whether the pull request carries the given label
+func (pr *PullRequest) HasLabel(label string) bool {
+	for _, l := range pr.Labels {
+		if strings.EqualFold(strings.TrimSpace(l), strings.TrimSpace(label)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Comment is a provider independent view of a newly created comment on an
+// issue, pull request or merge request carried by a hook
+type Comment struct {
+	Body   string
+	Author string
+	// AuthorAssociation is the author's relation to the repository e.g. OWNER, MEMBER
+	AuthorAssociation string
+}
+
+// SignatureHeaders are the headers set by Sign, they carry the webhook secret
+// or are derived from it
+var SignatureHeaders = []string{XHubSignature, XHubSignature256, XGitlabToken}
+
+// Push is a provider independent view of the commits of a push carried by a hook
+type Push struct {
+	// Before is the SHA of the ref before the push
+	Before string
+	// After is the SHA of the ref after the push
+	After string
+}
+
+// setJSONField replaces the value of key in the JSON object payload, keeping
+// all other fields untouched
+func setJSONField(payload []byte, key string, value interface{}) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	fields[key] = encoded
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+// XDeliveryID is the header holding the ID of an asynchronous delivery in the response
+const XDeliveryID = "X-Gwp-Delivery-Id"
+
+// Delivery is a hook accepted for delivery to its upstreams in the background
+type Delivery struct {
+	ID           string          `json:"id"`
+	Hook         *providers.Hook `json:"hook"`
+	RedirectURLs []string        `json:"redirectURLs"`
+	Policy       string          `json:"policy"`
+	// Source is the incoming request URL
+	Source     string    `json:"source"`
+	AcceptedAt time.Time `json:"acceptedAt"`
+	// OrderingKey is set for deliveries which are delivered in arrival order
+	// with all other deliveries of the same key
+	OrderingKey string `json:"orderingKey,omitempty"`
+	// Priority decides which waiting delivery a worker takes first
+	Priority string `json:"priority,omitempty"`
+	// sequence is the delivery's ticket of its ordering key
+	sequence uint64
+	// LastError and FailedAt are set once the delivery is dead-lettered
+	LastError string    `json:"lastError,omitempty"`
+	FailedAt  time.Time `json:"failedAt,omitempty"`
+}
+
+// newDeliveryID returns a random ID for a delivery
+func newDeliveryID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// startWorkers creates the delivery queue and starts the workers delivering from it
+func (p *Proxy) startWorkers(workers int, queueSize int) {
+	p.deliveries = newDeliveryQueue(queueSize)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+}
+
+func (p *Proxy) worker() {
+	for {
+		delivery := p.deliveries.take()
+		if len(delivery.OrderingKey) == 0 {
+			p.process(delivery)
+			continue
+		}
+		ordered := delivery
+		p.sequencer.run(delivery.OrderingKey, delivery.sequence, func() {
+			p.process(ordered)
+		})
+	}
+}
+
+// process delivers the delivery and dead-letters it or keeps it in the queue
+// directory if it failed
+func (p *Proxy) process(delivery *Delivery) {
+	var results []targetResult
+	for {
+		// Keep the delivery queued while the circuit of an upstream is open,
+		// ordered deliveries wait in place so later ones can't overtake them
+		for wait := p.circuitWait(delivery.RedirectURLs); wait > 0; wait = p.circuitWait(delivery.RedirectURLs) {
+			log.Printf("Circuit breaker of an upstream of '%s' is open, delaying delivery by %s\n", delivery.ID, wait)
+			if len(delivery.OrderingKey) == 0 {
+				p.requeue(delivery, wait)
+				return
+			}
+			time.Sleep(wait)
+		}
+
+		results = p.deliver(delivery)
+		pending := p.circuitOpened(delivery, results)
+		if pending == nil {
+			break
+		}
+		delivery = pending
+		if len(delivery.OrderingKey) == 0 {
+			p.requeue(delivery, p.circuitWait(delivery.RedirectURLs))
+			return
+		}
+	}
+
+	failed := combinedStatusCode(delivery.Policy, results) >= 400
+	if failed {
+		p.deadLetter(delivery, results)
+	}
+	if p.store == nil {
+		return
+	}
+
+	if failed && p.deadLetters == nil {
+		log.Printf("Keeping undelivered '%s' in queue directory\n", delivery.ID)
+		return
+	}
+	if err := p.store.remove(delivery.ID); err != nil {
+		log.Printf("Error removing delivered '%s' from queue directory: %s\n", delivery.ID, err)
+	}
+}
+
+// circuitOpened returns the delivery to the failed upstreams if the circuit
+// of one of them opened while the delivery was in flight, so it is queued
+// again instead of failing. It returns nil if the delivery is done.
+func (p *Proxy) circuitOpened(delivery *Delivery, results []targetResult) *Delivery {
+	if combinedStatusCode(delivery.Policy, results) < 400 {
+		return nil
+	}
+
+	failed := failedTargets(delivery.Policy, results)
+	opened := false
+	redirectURLs := make([]string, len(failed))
+	for i, result := range failed {
+		opened = opened || result.Err == errCircuitOpen
+		redirectURLs[i] = result.URL
+	}
+	if !opened {
+		return nil
+	}
+
+	log.Printf("Circuit breaker of an upstream of '%s' opened during delivery, queueing it for upstreams %v\n",
+		delivery.ID, redirectURLs)
+	pending := *delivery
+	pending.RedirectURLs = redirectURLs
+	// Upstreams which accepted the delivery don't get it again after a restart
+	if p.store != nil {
+		if err := p.store.save(&pending); err != nil {
+			log.Printf("Error storing delivery '%s': %s\n", delivery.ID, err)
+		}
+	}
+	return &pending
+}
+
+// requeue queues the delivery again after wait
+func (p *Proxy) requeue(delivery *Delivery, wait time.Duration) {
+	time.AfterFunc(wait, func() {
+		p.deliveries.put(delivery)
+	})
+}
+
+// resumePending queues the deliveries left in the store by a previous run
+func (p *Proxy) resumePending(pending []*Delivery) {
+	log.Printf("Resuming %d pending deliveries\n", len(pending))
+	// The pending deliveries are sorted by their arrival
+	for _, delivery := range pending {
+		p.restoreHeaders(delivery)
+		p.reserve(delivery)
+	}
+	go func() {
+		for _, delivery := range pending {
+			p.deliveries.put(delivery)
+		}
+	}()
+}
+
+// reserve takes the delivery's place in the order of its ordering key
+func (p *Proxy) reserve(delivery *Delivery) {
+	if len(delivery.OrderingKey) > 0 {
+		delivery.sequence = p.sequencer.reserve(delivery.OrderingKey)
+	}
+}
+
+// release gives up the place of a delivery which won't be delivered
+func (p *Proxy) release(delivery *Delivery) {
+	if len(delivery.OrderingKey) > 0 {
+		p.sequencer.run(delivery.OrderingKey, delivery.sequence, nil)
+	}
+}
+
+// enqueue queues the delivery without blocking, it fails if the queue is full
+func (p *Proxy) enqueue(delivery *Delivery) error {
+	return p.deliveries.offer(delivery)
+}
+
+// deliver sends the delivery to its upstreams and logs the results
+func (p *Proxy) deliver(delivery *Delivery) []targetResult {
+	results := p.fanOut(delivery.Hook, delivery.RedirectURLs)
+	for _, result := range results {
+		log.Printf("Delivered '%s' from '%s' to %s\n", delivery.ID, delivery.Source, result)
+	}
+	return results
+}
+
+// proxyAsync queues the hook for delivery and acknowledges it immediately with 202 Accepted
+func (p *Proxy) proxyAsync(w http.ResponseWriter, r *http.Request, hook *providers.Hook,
+	redirectURLs []string, policy string, orderingKey string, priority string) {
+	id, err := newDeliveryID()
+	if err != nil {
+		log.Printf("Error creating delivery ID: %s", err)
+		http.Error(w, "Error creating delivery ID", http.StatusInternalServerError)
+		return
+	}
+
+	delivery := &Delivery{
+		ID:           id,
+		Hook:         hook,
+		RedirectURLs: redirectURLs,
+		Policy:       policy,
+		Source:       r.URL.String(),
+		AcceptedAt:   time.Now(),
+		OrderingKey:  orderingKey,
+		Priority:     priority,
+	}
+
+	// Take the delivery's place in the order on arrival, workers may take
+	// deliveries of the same key from the queue in any order
+	p.reserve(delivery)
+
+	// Store the delivery before acknowledging it so it survives a restart
+	if p.store != nil {
+		if err := p.store.save(delivery); err != nil {
+			p.release(delivery)
+			log.Printf("Error storing delivery from '%s': %s", r.URL, err)
+			http.Error(w, "Error storing delivery", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := p.enqueue(delivery); err != nil {
+		log.Printf("Error queueing delivery from '%s': %s", r.URL, err)
+		p.release(delivery)
+		if p.store != nil {
+			p.store.remove(delivery.ID)
+		}
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	log.Printf("Accepted delivery '%s' from '%s' to upstreams %v\n", id, r.URL, redirectURLs)
+	w.Header().Set(XDeliveryID, id)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Accepted delivery " + id))
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestProxy_proxyAsync(t *testing.T) {
+	received := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, proxyGitlabTestSecret, []string{},
+		WithAsyncDelivery(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := httprouter.New()
+	router.POST("/*path", p.proxyRequest)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, createGitlabRequest(http.MethodPost, "/post",
+		proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody))
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	if rr.Header().Get(XDeliveryID) == "" {
+		t.Errorf("handler returned no %s header", XDeliveryID)
+	}
+
+	select {
+	case body := <-received:
+		if body != proxyGitlabTestBody {
+			t.Errorf("upstream received body %v, want %v", body, proxyGitlabTestBody)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("upstream did not receive the delivery")
+	}
+}
+
+func TestProxy_proxyAsyncWithFullQueue(t *testing.T) {
+	p := &Proxy{
+		provider:    providers.GitlabProviderKind,
+		upstreamURL: "http://localhost",
+		secret:      proxyGitlabTestSecret,
+		deliveries:  newDeliveryQueue(0),
+	}
+	router := httprouter.New()
+	router.POST("/*path", p.proxyRequest)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, createGitlabRequest(http.MethodPost, "/post",
+		proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AuthBearer sends Token as bearer token in the Authorization header
+	AuthBearer = "bearer"
+	// AuthBasic sends Username and Password as basic auth
+	AuthBasic = "basic"
+	// AuthHeader sends Token in the custom header Header
+	AuthHeader = "header"
+	// AuthQuery sends Token in the query string parameter Parameter, e.g. Jenkins' ?token=
+	AuthQuery = "query"
+	// AuthOAuth2 sends a bearer token requested from TokenURL with the OAuth2
+	// client credentials grant
+	AuthOAuth2 = "oauth2"
+
+	defaultAuthQueryParameter = "token"
+	// tokenExpiryMargin is how long before their expiry OAuth2 tokens are refreshed
+	tokenExpiryMargin = 30 * time.Second
+)
+
+// UpstreamAuth configures the credentials sent to the upstream hosts matching
+// Hosts, or to all upstreams if Hosts is empty. Every secret can be read from
+// a file instead, which is read again for every request so it can be rotated.
+type UpstreamAuth struct {
+	// Hosts are glob patterns of upstream hosts, e.g. *.jenkins.svc
+	Hosts []string `json:"hosts"`
+	// Type is one of bearer, basic, header, query or oauth2
+	Type string `json:"type"`
+	// Token is the bearer token, header value or query string token
+	Token     string `json:"token"`
+	TokenFile string `json:"tokenFile"`
+	// Username and Password are the basic auth credentials
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"passwordFile"`
+	// Header is the name of the header of the header type
+	Header string `json:"header"`
+	// Parameter is the query string parameter of the query type, token if empty
+	Parameter string `json:"parameter"`
+	// TokenURL, ClientID, ClientSecret and Scopes are the OAuth2 client credentials
+	TokenURL         string   `json:"tokenURL"`
+	ClientID         string   `json:"clientID"`
+	ClientSecret     string   `json:"clientSecret"`
+	ClientSecretFile string   `json:"clientSecretFile"`
+	Scopes           []string `json:"scopes"`
+}
+
+// upstreamAuthenticator authenticates the requests to the upstream hosts of
+// its UpstreamAuth and caches its OAuth2 token
+type upstreamAuthenticator struct {
+	UpstreamAuth
+
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// readSecret returns the content of file if it is set, else value
+func readSecret(value string, file string) (string, error) {
+	if len(file) == 0 {
+		return value, nil
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func (u UpstreamAuth) validate() error {
+	for _, pattern := range u.Hosts {
+		if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+			return errors.New("invalid host pattern '" + pattern + "'")
+		}
+	}
+
+	required := map[string]string{}
+	secret, secretFile := u.Token, u.TokenFile
+	switch u.Type {
+	case AuthBearer, AuthQuery:
+	case AuthHeader:
+		required["header"] = u.Header
+	case AuthBasic:
+		required["username"] = u.Username
+		secret, secretFile = u.Password, u.PasswordFile
+	case AuthOAuth2:
+		required["tokenURL"] = u.TokenURL
+		required["clientID"] = u.ClientID
+		secret, secretFile = u.ClientSecret, u.ClientSecretFile
+		if _, err := url.ParseRequestURI(u.TokenURL); len(u.TokenURL) > 0 && err != nil {
+			return errors.New("invalid tokenURL '" + u.TokenURL + "'")
+		}
+	default:
+		return errors.New("unknown type '" + u.Type + "'")
+	}
+	for name, value := range required {
+		if len(value) == 0 {
+			return errors.New(u.Type + " auth without " + name)
+		}
+	}
+
+	secret, err := readSecret(secret, secretFile)
+	if err != nil {
+		return err
+	}
+	if len(secret) == 0 {
+		return errors.New(u.Type + " auth without secret")
+	}
+	return nil
+}
+
+// authenticatorFor returns the authenticator of the first upstream auth
+// matching the host of redirectURL
+func (p *Proxy) authenticatorFor(redirectURL string) *upstreamAuthenticator {
+	host := upstreamHost(redirectURL)
+	for _, authenticator := range p.upstreamAuthenticators {
+		if len(authenticator.Hosts) == 0 || matchesHost(authenticator.Hosts, host) {
+			return authenticator
+		}
+	}
+	return nil
+}
+
+// authenticate adds the credentials to the request
+func (p *Proxy) authenticate(a *upstreamAuthenticator, req *http.Request) error {
+	if a.Type == AuthOAuth2 {
+		token, err := p.accessToken(a)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+
+	if a.Type == AuthBasic {
+		password, err := readSecret(a.Password, a.PasswordFile)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(a.Username, password)
+		return nil
+	}
+
+	token, err := readSecret(a.Token, a.TokenFile)
+	if err != nil {
+		return err
+	}
+	switch a.Type {
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+token)
+	case AuthHeader:
+		req.Header.Set(a.Header, token)
+	case AuthQuery:
+		query := req.URL.Query()
+		query.Set(a.queryParameter(), token)
+		req.URL.RawQuery = query.Encode()
+	}
+	return nil
+}
+
+// accessToken returns the cached OAuth2 token, or requests a new one with the
+// client credentials grant if there is none or it is about to expire
+func (p *Proxy) accessToken(a *upstreamAuthenticator) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if len(a.token) > 0 && (a.expiry.IsZero() || time.Now().Before(a.expiry)) {
+		return a.token, nil
+	}
+
+	clientSecret, err := readSecret(a.ClientSecret, a.ClientSecretFile)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	req, err := http.NewRequest(http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(clientSecret))
+
+	resp, err := p.clientFor(a.TokenURL).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", errors.New("OAuth2 token request to '" + a.TokenURL + "' failed with status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if len(token.AccessToken) == 0 {
+		return "", errors.New("OAuth2 token response of '" + a.TokenURL + "' has no access_token")
+	}
+
+	a.token = token.AccessToken
+	a.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		a.expiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	}
+	return a.token, nil
+}
+
+// invalidate drops the cached OAuth2 token after the upstream rejected it, so
+// the next attempt requests a new one
+func (a *upstreamAuthenticator) invalidate(token string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.token == token {
+		a.token = ""
+	}
+}
+
+// queryParameter returns the query string parameter of the query type
+func (a *upstreamAuthenticator) queryParameter() string {
+	if len(a.Parameter) == 0 {
+		return defaultAuthQueryParameter
+	}
+	return a.Parameter
+}
+
+// redact removes the query string token from the URL of a failed request's
+// error, as the error is logged, dead-lettered and returned to the Git provider
+func (a *upstreamAuthenticator) redact(err error) error {
+	urlErr, ok := err.(*url.Error)
+	if !ok || a.Type != AuthQuery {
+		return err
+	}
+	parsed, parseErr := url.Parse(urlErr.URL)
+	if parseErr != nil {
+		return &url.Error{Op: urlErr.Op, URL: "", Err: urlErr.Err}
+	}
+	query := parsed.Query()
+	if _, ok := query[a.queryParameter()]; ok {
+		query.Set(a.queryParameter(), "REDACTED")
+		parsed.RawQuery = query.Encode()
+	}
+	return &url.Error{Op: urlErr.Op, URL: parsed.String(), Err: urlErr.Err}
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestProxy_redirectOnceWithUpstreamAuth(t *testing.T) {
+	var request *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	dir, err := ioutil.TempDir("", "gwp-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("filetoken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		upstreamAuth []UpstreamAuth
+		header       string
+		want         string
+		wantQuery    string
+	}{
+		{
+			name:   "TestRedirectOnceWithoutUpstreamAuth",
+			header: "Authorization",
+			want:   "",
+		},
+		{
+			name:         "TestRedirectOnceWithUpstreamAuthOfOtherHost",
+			upstreamAuth: []UpstreamAuth{{Hosts: []string{"jenkins.example.com"}, Type: AuthBearer, Token: "token"}},
+			header:       "Authorization",
+			want:         "",
+		},
+		{
+			name:         "TestRedirectOnceWithBearerTokenFile",
+			upstreamAuth: []UpstreamAuth{{Hosts: []string{"127.0.0.*"}, Type: AuthBearer, TokenFile: tokenFile}},
+			header:       "Authorization",
+			want:         "Bearer filetoken",
+		},
+		{
+			name:         "TestRedirectOnceWithBasicAuth",
+			upstreamAuth: []UpstreamAuth{{Type: AuthBasic, Username: "jenkins", Password: "password"}},
+			header:       "Authorization",
+			want:         "Basic amVua2luczpwYXNzd29yZA==",
+		},
+		{
+			name:         "TestRedirectOnceWithCustomHeader",
+			upstreamAuth: []UpstreamAuth{{Type: AuthHeader, Header: "X-Api-Key", Token: "key"}},
+			header:       "X-Api-Key",
+			want:         "key",
+		},
+		{
+			name:         "TestRedirectOnceWithQueryToken",
+			upstreamAuth: []UpstreamAuth{{Type: AuthQuery, TokenFile: tokenFile}},
+			wantQuery:    "job=build&token=filetoken",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, "", []string{},
+				WithUpstreamAuth(tt.upstreamAuth))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hook := createGitlabHook(proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost)
+			resp, err := p.redirectOnce(hook, upstream.URL+"/post?job=build")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if len(tt.header) > 0 {
+				if got := request.Header.Get(tt.header); got != tt.want {
+					t.Errorf("Proxy.redirectOnce() sent %v = %v, want %v", tt.header, got, tt.want)
+				}
+			}
+			if len(tt.wantQuery) > 0 && request.URL.RawQuery != tt.wantQuery {
+				t.Errorf("Proxy.redirectOnce() sent query %v, want %v", request.URL.RawQuery, tt.wantQuery)
+			}
+		})
+	}
+}
+
+func TestProxy_redirectOnceWithOAuth2(t *testing.T) {
+	var issued int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != "gitwebhookproxy" || clientSecret != "clientsecret" ||
+			r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("scope") != "webhooks" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if atomic.AddInt32(&issued, 1) == 1 {
+			w.Write([]byte(`{"access_token":"first","token_type":"bearer","expires_in":3600}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"second","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	var rejectFirst int32 = 1
+	var authorization string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if authorization == "Bearer first" && atomic.LoadInt32(&rejectFirst) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, "", []string{},
+		WithUpstreamAuth([]UpstreamAuth{{Type: AuthOAuth2, TokenURL: tokenServer.URL, ClientID: "gitwebhookproxy",
+			ClientSecret: "clientsecret", Scopes: []string{"webhooks"}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := createGitlabHook(proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost)
+
+	for _, want := range []struct {
+		authorization string
+		status        int
+	}{
+		{"Bearer first", http.StatusOK},
+		// The cached token is used until the upstream rejects it
+		{"Bearer first", http.StatusUnauthorized},
+		{"Bearer second", http.StatusOK},
+		{"Bearer second", http.StatusOK},
+	} {
+		resp, err := p.redirectOnce(hook, upstream.URL+"/post")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if authorization != want.authorization || resp.StatusCode != want.status {
+			t.Errorf("Proxy.redirectOnce() sent %v and got %v, want %v and %v",
+				authorization, resp.StatusCode, want.authorization, want.status)
+		}
+		atomic.StoreInt32(&rejectFirst, 0)
+	}
+	if issued := atomic.LoadInt32(&issued); issued != 2 {
+		t.Errorf("OAuth2 tokens requested %v times, want 2", issued)
+	}
+}
+
+func TestNewProxyWithInvalidUpstreamAuth(t *testing.T) {
+	for _, upstreamAuth := range []UpstreamAuth{
+		{Type: "digest", Token: "token"},
+		{Type: AuthBearer},
+		{Type: AuthBearer, TokenFile: "/does/not/exist"},
+		{Type: AuthBasic, Password: "password"},
+		{Type: AuthHeader, Token: "token"},
+		{Type: AuthOAuth2, ClientID: "gitwebhookproxy", ClientSecret: "secret"},
+		{Hosts: []string{"["}, Type: AuthBearer, Token: "token"},
+	} {
+		if _, err := NewProxy("http://localhost", []string{}, providers.GitlabProviderKind, "", []string{},
+			WithUpstreamAuth([]UpstreamAuth{upstreamAuth})); err == nil {
+			t.Errorf("NewProxy() with upstream auth %+v error = nil, want an error", upstreamAuth)
+		}
+	}
+}
+
+func TestProxy_proxyRequestWithQueryTokenRedacted(t *testing.T) {
+	// A closed port makes the request fail with an error containing its URL
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	dir, err := ioutil.TempDir("", "gwp-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	p, err := NewProxy(closed.URL, []string{}, providers.GitlabProviderKind, proxyGitlabTestSecret, []string{},
+		WithUpstreamAuth([]UpstreamAuth{{Type: AuthQuery, Token: "querysecret"}}),
+		WithDeadLetterDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := createGitlabHook(proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost)
+	if _, err := p.redirectOnce(hook, closed.URL+"/post"); err == nil || strings.Contains(err.Error(), "querysecret") {
+		t.Errorf("Proxy.redirectOnce() error = %v, want an error without the token", err)
+	}
+
+	router := httprouter.New()
+	router.POST("/*path", p.proxyRequest)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, createGitlabRequest(http.MethodPost, "/post",
+		proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody))
+
+	deadLetters, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) == 0 {
+		t.Fatalf("proxyRequest() did not dead-letter the failed request")
+	}
+	for _, info := range deadLetters {
+		content, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(content), "querysecret") {
+			t.Errorf("dead letter %v contains the query token", info.Name())
+		}
+	}
+	if strings.Contains(rr.Body.String(), "querysecret") {
+		t.Errorf("handler returned the query token in %v", rr.Body.String())
+	}
+	if strings.Contains(logs.String(), "querysecret") {
+		t.Errorf("proxy logged the query token")
+	}
+}
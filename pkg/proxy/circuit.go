@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+const (
+	// CircuitClosed lets all requests through to the upstream
+	CircuitClosed = "closed"
+	// CircuitOpen fails requests to the upstream without sending them
+	CircuitOpen = "open"
+	// CircuitHalfOpen lets a single probe request through to the upstream
+	CircuitHalfOpen = "half-open"
+)
+
+var errCircuitOpen = errors.New("Circuit breaker is open")
+
+// CircuitStatus is the state of the circuit breaker of one upstream
+type CircuitStatus struct {
+	Upstream string     `json:"upstream"`
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+}
+
+// circuitBreaker stops sending requests to an upstream after failureThreshold
+// consecutive failures. Once openDuration passed a single probe is let
+// through, its outcome closes or opens the circuit again.
+type circuitBreaker struct {
+	upstream         string
+	failureThreshold int
+	openDuration     time.Duration
+
+	mutex    sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(upstream string, failureThreshold int, openDuration time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		upstream:         upstream,
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+		state:            CircuitClosed,
+	}
+}
+
+// setState must be called with the mutex held
+func (b *circuitBreaker) setState(state string) {
+	if b.state != state {
+		log.Printf("Circuit breaker for upstream '%s' is now %s\n", b.upstream, state)
+	}
+	b.state = state
+}
+
+// allow checks whether a request may be sent to the upstream, a request
+// allowed in the half-open state is the probe
+func (b *circuitBreaker) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.openDuration {
+		b.setState(CircuitHalfOpen)
+	}
+
+	switch b.state {
+	case CircuitClosed:
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return false
+	}
+}
+
+// record updates the circuit with the outcome of an allowed request
+func (b *circuitBreaker) record(success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+	if success {
+		b.failures = 0
+		b.setState(CircuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = time.Now()
+		b.setState(CircuitOpen)
+	}
+}
+
+// cancel gives up an allowed request which was never sent, e.g. as the
+// upstream limits rejected it, so another request may probe the upstream
+func (b *circuitBreaker) cancel() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+// retryIn returns how long requests to the upstream will fail fast, zero if
+// a request would be allowed
+func (b *circuitBreaker) retryIn() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch {
+	case b.state == CircuitOpen:
+		if remaining := b.openDuration - time.Since(b.openedAt); remaining > 0 {
+			return remaining
+		}
+		return 0
+	case b.state == CircuitHalfOpen && b.probing:
+		return b.openDuration
+	default:
+		return 0
+	}
+}
+
+func (b *circuitBreaker) status() CircuitStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := CircuitStatus{Upstream: b.upstream, State: b.state, Failures: b.failures}
+	if b.state != CircuitClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// upstreamKey identifies the upstream of redirectURL by its scheme and host
+func upstreamKey(redirectURL string) string {
+	if !strings.Contains(redirectURL, "://") {
+		redirectURL = "http://" + redirectURL
+	}
+	parsed, err := url.Parse(redirectURL)
+	if err != nil {
+		return redirectURL
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+// breakerFor returns the circuit breaker of the upstream of redirectURL, nil
+// if circuit breaking is disabled
+func (p *Proxy) breakerFor(redirectURL string) *circuitBreaker {
+	if p.circuitFailureThreshold == 0 {
+		return nil
+	}
+
+	key := upstreamKey(redirectURL)
+	p.breakersMutex.Lock()
+	defer p.breakersMutex.Unlock()
+	if p.breakers == nil {
+		p.breakers = map[string]*circuitBreaker{}
+	}
+	breaker, ok := p.breakers[key]
+	if !ok {
+		breaker = newCircuitBreaker(key, p.circuitFailureThreshold, p.circuitOpenDuration)
+		p.breakers[key] = breaker
+	}
+	return breaker
+}
+
+// redirectThroughBreaker sends the hook to redirectURL in a single attempt
+// within the limits of its upstream, unless its circuit is open. Network
+// errors and 5xx responses count as failures of the upstream, requests
+// rejected by the limits don't count at all.
+func (p *Proxy) redirectThroughBreaker(hook *providers.Hook, redirectURL string) (*http.Response, error) {
+	breaker := p.breakerFor(redirectURL)
+	if breaker == nil {
+		return p.redirectWithinLimits(hook, redirectURL)
+	}
+	if !breaker.allow() {
+		return nil, errCircuitOpen
+	}
+
+	resp, err := p.redirectWithinLimits(hook, redirectURL)
+	if limitStatusCode(err) != 0 {
+		breaker.cancel()
+		return nil, err
+	}
+	breaker.record(err == nil && resp.StatusCode < 500)
+	return resp, err
+}
+
+// circuitWait returns how long the circuits of any of redirectURLs stay open
+func (p *Proxy) circuitWait(redirectURLs []string) time.Duration {
+	var wait time.Duration
+	for _, redirectURL := range redirectURLs {
+		if breaker := p.breakerFor(redirectURL); breaker != nil {
+			if retryIn := breaker.retryIn(); retryIn > wait {
+				wait = retryIn
+			}
+		}
+	}
+	return wait
+}
+
+// circuitStatuses returns the state of all circuit breakers ordered by upstream
+func (p *Proxy) circuitStatuses() []CircuitStatus {
+	p.breakersMutex.Lock()
+	defer p.breakersMutex.Unlock()
+
+	statuses := []CircuitStatus{}
+	for _, breaker := range p.breakers {
+		statuses = append(statuses, breaker.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Upstream < statuses[j].Upstream
+	})
+	return statuses
+}
+
+// Status Endpoint reporting the circuit breakers of the upstreams
+func (p *Proxy) status(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"circuitBreakers": p.circuitStatuses(),
+	})
+}
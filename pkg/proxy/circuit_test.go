@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := newCircuitBreaker("http://jenkins", 2, 50*time.Millisecond)
+
+	breaker.record(false)
+	if !breaker.allow() {
+		t.Fatalf("circuit opened before reaching the failure threshold")
+	}
+	breaker.record(false)
+	if breaker.allow() {
+		t.Fatalf("circuit did not open after reaching the failure threshold")
+	}
+	if breaker.retryIn() <= 0 {
+		t.Errorf("retryIn() of open circuit = %v, want > 0", breaker.retryIn())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !breaker.allow() {
+		t.Fatalf("circuit did not let a probe through after the open duration")
+	}
+	if breaker.status().State != CircuitHalfOpen {
+		t.Errorf("state = %v, want %v", breaker.status().State, CircuitHalfOpen)
+	}
+	if breaker.allow() {
+		t.Errorf("half-open circuit let a second probe through")
+	}
+
+	breaker.record(false)
+	if breaker.status().State != CircuitOpen {
+		t.Fatalf("failed probe did not open the circuit again")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	breaker.allow()
+	breaker.record(true)
+	if status := breaker.status(); status.State != CircuitClosed || status.Failures != 0 {
+		t.Errorf("status after successful probe = %+v, want closed without failures", status)
+	}
+}
+
+func TestUpstreamKey(t *testing.T) {
+	tests := map[string]string{
+		"https://jenkins:8443/github-webhook/": "https://jenkins:8443",
+		"jenkins.example.com/post":             "http://jenkins.example.com",
+	}
+	for redirectURL, want := range tests {
+		if got := upstreamKey(redirectURL); got != want {
+			t.Errorf("upstreamKey(%v) = %v, want %v", redirectURL, got, want)
+		}
+	}
+}
+
+func TestProxy_redirectWithOpenCircuit(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	p := &Proxy{}
+	WithCircuitBreaker(2, time.Minute)(p)
+	hook := createGitlabHook(proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost)
+
+	for i := 0; i < 2; i++ {
+		resp, err := p.redirect(hook, upstream.URL+"/post")
+		if err != nil {
+			t.Fatalf("redirect() error = %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if _, err := p.redirect(hook, upstream.URL+"/other"); err != errCircuitOpen {
+		t.Errorf("redirect() error = %v, want %v", err, errCircuitOpen)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("upstream received %d requests, want 2", got)
+	}
+
+	rr := httptest.NewRecorder()
+	p.adminRouter().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status struct {
+		CircuitBreakers []CircuitStatus `json:"circuitBreakers"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("status returned invalid JSON %v: %v", rr.Body.String(), err)
+	}
+	if len(status.CircuitBreakers) != 1 || status.CircuitBreakers[0].State != CircuitOpen {
+		t.Errorf("status returned %v, want one open circuit", status.CircuitBreakers)
+	}
+}
+
+func TestProxy_processWithCircuitOpenedInFlight(t *testing.T) {
+	tests := []struct {
+		name        string
+		orderingKey string
+	}{
+		{
+			name: "TestProcessRequeuesWithCircuitOpenedInFlight",
+		},
+		{
+			name:        "TestProcessWaitsWithCircuitOpenedInFlight",
+			orderingKey: "stakater/GitWebhookProxy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			delivered := make(chan struct{})
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The first attempt opens the circuit, the retry finds it open
+				if atomic.AddInt32(&requests, 1) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				close(delivered)
+			}))
+			defer upstream.Close()
+
+			dir, err := ioutil.TempDir("", "gwp-circuit")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, "", []string{},
+				WithCircuitBreaker(1, 50*time.Millisecond), WithRetries(1, time.Millisecond, time.Millisecond, 0),
+				WithDeadLetterDir(dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.deliveries = newDeliveryQueue(1)
+			go p.worker()
+
+			p.process(&Delivery{
+				ID:           "circuit",
+				Hook:         createGitlabHook("", proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost),
+				RedirectURLs: []string{upstream.URL + "/post"},
+				Policy:       FanOutPolicyAll,
+				OrderingKey:  tt.orderingKey,
+			})
+
+			select {
+			case <-delivered:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("delivery was not retried after the circuit closed")
+			}
+			if deadLetters, _ := ioutil.ReadDir(dir); len(deadLetters) != 0 {
+				t.Errorf("delivery was dead-lettered while the circuit was open")
+			}
+		})
+	}
+}
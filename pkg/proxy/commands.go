@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+// Header constants for comment commands forwarded to the upstream
+const (
+	XCommand     = "X-Gwp-Command"
+	XCommandArgs = "X-Gwp-Command-Args"
+	XCommandArg  = "X-Gwp-Command-Arg-"
+)
+
+var defaultCommandAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+// Command triggers a specific upstream path when a comment matches Pattern,
+// e.g. `/retest` or `/deploy staging`
+type Command struct {
+	Name string `json:"name"`
+	// Pattern is the regex matched against the comment body. Capture groups
+	// are forwarded as the command arguments.
+	Pattern string `json:"pattern"`
+	// Path on the upstream to which the comment is proxied
+	Path string `json:"path"`
+	// AllowedAssociations are the Github author associations trusted to run
+	// the command, defaults to OWNER, MEMBER and COLLABORATOR
+	AllowedAssociations []string `json:"allowedAssociations"`
+	// AllowedUsers are the users trusted to run the command
+	AllowedUsers []string `json:"allowedUsers"`
+
+	regex *regexp.Regexp
+}
+
+func (c *Command) compile() error {
+	if len(strings.TrimSpace(c.Name)) == 0 {
+		return errors.New("Command with empty name specified")
+	}
+
+	regex, err := regexp.Compile(c.Pattern)
+	if err != nil {
+		return errors.New("Invalid pattern for command '" + c.Name + "': " + err.Error())
+	}
+	c.regex = regex
+
+	if c.AllowedAssociations == nil && c.AllowedUsers == nil {
+		c.AllowedAssociations = defaultCommandAssociations
+	}
+	return nil
+}
+
+// match returns the arguments of the command if the comment body matches it
+func (c *Command) match(body string) ([]string, bool) {
+	matches := c.regex.FindStringSubmatch(strings.TrimSpace(body))
+	if matches == nil {
+		return nil, false
+	}
+
+	if len(matches) > 1 {
+		return matches[1:], true
+	}
+	fields := strings.Fields(matches[0])
+	if len(fields) > 1 {
+		return fields[1:], true
+	}
+	return []string{}, true
+}
+
+// isTrusted checks whether the author of the comment may run the command
+func (c *Command) isTrusted(comment *providers.Comment) bool {
+	if matchesUser(c.AllowedUsers, comment.Author) {
+		return true
+	}
+
+	for _, association := range c.AllowedAssociations {
+		if strings.EqualFold(strings.TrimSpace(association), comment.AuthorAssociation) {
+			return true
+		}
+	}
+	return false
+}
+
+// findCommand returns the first command matching the comment with its arguments
+func (p *Proxy) findCommand(comment *providers.Comment) (*Command, []string) {
+	if comment == nil {
+		return nil, nil
+	}
+
+	for i := range p.commands {
+		if args, ok := p.commands[i].match(comment.Body); ok {
+			return &p.commands[i], args
+		}
+	}
+	return nil, nil
+}
+
+// setCommandHeaders adds the command and its arguments to the headers of the hook
+func setCommandHeaders(hook *providers.Hook, command *Command, args []string) {
+	hook.Headers[XCommand] = command.Name
+	hook.Headers[XCommandArgs] = strings.Join(args, " ")
+	for i, arg := range args {
+		hook.Headers[XCommandArg+strconv.Itoa(i+1)] = arg
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestProxy_findCommand(t *testing.T) {
+	commands := []Command{
+		{Name: "retest", Pattern: `^/retest$`, Path: "/job/retest"},
+		{Name: "deploy", Pattern: `^/deploy\s+(\w+)$`, Path: "/job/deploy"},
+		{Name: "label", Pattern: `^/label\b.*$`, Path: "/job/label"},
+	}
+	for i := range commands {
+		if err := commands[i].compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		comment  *providers.Comment
+		wantName string
+		wantArgs []string
+	}{
+		{
+			name:    "TestFindCommandWithNoComment",
+			comment: nil,
+		},
+		{
+			name:    "TestFindCommandWithPlainComment",
+			comment: &providers.Comment{Body: "LGTM"},
+		},
+		{
+			name:     "TestFindCommandWithoutArguments",
+			comment:  &providers.Comment{Body: "/retest\n"},
+			wantName: "retest",
+			wantArgs: []string{},
+		},
+		{
+			name:     "TestFindCommandWithCaptureGroup",
+			comment:  &providers.Comment{Body: "/deploy staging"},
+			wantName: "deploy",
+			wantArgs: []string{"staging"},
+		},
+		{
+			name:     "TestFindCommandWithFieldArguments",
+			comment:  &providers.Comment{Body: "/label bug urgent"},
+			wantName: "label",
+			wantArgs: []string{"bug", "urgent"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{commands: commands}
+			command, args := p.findCommand(tt.comment)
+			if command == nil {
+				if tt.wantName != "" {
+					t.Errorf("Proxy.findCommand() = nil, want %v", tt.wantName)
+				}
+				return
+			}
+			if command.Name != tt.wantName {
+				t.Errorf("Proxy.findCommand() = %v, want %v", command.Name, tt.wantName)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("Proxy.findCommand() args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCommand_isTrusted(t *testing.T) {
+	tests := []struct {
+		name    string
+		command Command
+		comment *providers.Comment
+		want    bool
+	}{
+		{
+			name:    "TestIsTrustedWithDefaultAssociations",
+			command: Command{Name: "retest"},
+			comment: &providers.Comment{Author: "user", AuthorAssociation: "MEMBER"},
+			want:    true,
+		},
+		{
+			name:    "TestIsTrustedWithUntrustedAssociation",
+			command: Command{Name: "retest"},
+			comment: &providers.Comment{Author: "user", AuthorAssociation: "NONE"},
+			want:    false,
+		},
+		{
+			name:    "TestIsTrustedWithAllowedUser",
+			command: Command{Name: "retest", AllowedUsers: []string{"user"}},
+			comment: &providers.Comment{Author: "user"},
+			want:    true,
+		},
+		{
+			name:    "TestIsTrustedWithOnlyAllowedUsersConfigured",
+			command: Command{Name: "retest", AllowedUsers: []string{"user"}},
+			comment: &providers.Comment{Author: "other", AuthorAssociation: "OWNER"},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.command.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.command.isTrusted(tt.comment); got != tt.want {
+				t.Errorf("Command.isTrusted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// Config holds the Proxy configuration which is too structured for flags. It
+// is read from a JSON file.
+type Config struct {
+	Commands []Command `json:"commands"`
+	Routes   []Route   `json:"routes"`
+	// AllowedUpstreamHosts are glob patterns the hosts of templated upstream URLs must match
+	AllowedUpstreamHosts []string `json:"allowedUpstreamHosts"`
+	// UpstreamTLS configures the TLS connections to the upstream hosts
+	UpstreamTLS []UpstreamTLS `json:"upstreamTLS"`
+	// UpstreamSecrets are the secrets with which hooks are signed for the upstream hosts
+	UpstreamSecrets []UpstreamSecret `json:"upstreamSecrets"`
+	// UpstreamAuth are the credentials sent to the upstream hosts
+	UpstreamAuth []UpstreamAuth `json:"upstreamAuth"`
+	// Headers is the policy of the request headers forwarded to the upstreams
+	Headers HeaderPolicy `json:"headers"`
+}
+
+// LoadConfig reads Config from the JSON file at path
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+// DeadLetterSummary describes a dead-lettered delivery without its payload
+type DeadLetterSummary struct {
+	ID           string    `json:"id"`
+	RedirectURLs []string  `json:"redirectURLs"`
+	Source       string    `json:"source"`
+	LastError    string    `json:"lastError"`
+	AcceptedAt   time.Time `json:"acceptedAt"`
+	FailedAt     time.Time `json:"failedAt"`
+}
+
+// ReplayResult is the outcome of replaying a dead-lettered delivery to one upstream
+type ReplayResult struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func summarize(delivery *Delivery) DeadLetterSummary {
+	return DeadLetterSummary{
+		ID:           delivery.ID,
+		RedirectURLs: delivery.RedirectURLs,
+		Source:       delivery.Source,
+		LastError:    delivery.LastError,
+		AcceptedAt:   delivery.AcceptedAt,
+		FailedAt:     delivery.FailedAt,
+	}
+}
+
+// failedTargets returns the results of the upstreams which have to be
+// delivered again for the delivery to succeed under policy
+func failedTargets(policy string, results []targetResult) []targetResult {
+	if policy == FanOutPolicyPrimary {
+		if results[0].succeeded() {
+			return nil
+		}
+		return results[:1]
+	}
+
+	failed := []targetResult{}
+	for _, result := range results {
+		if !result.succeeded() {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// deadLetter stores a delivery whose retries are exhausted together with the
+// upstreams it failed for and their last error
+func (p *Proxy) deadLetter(delivery *Delivery, results []targetResult) {
+	if p.deadLetters == nil {
+		return
+	}
+
+	failed := failedTargets(delivery.Policy, results)
+	if len(failed) == 0 {
+		return
+	}
+
+	redirectURLs := make([]string, len(failed))
+	errs := make([]string, len(failed))
+	for i, result := range failed {
+		redirectURLs[i] = result.URL
+		errs[i] = result.String()
+	}
+
+	deadLetter := *delivery
+	deadLetter.RedirectURLs = redirectURLs
+	deadLetter.Policy = FanOutPolicyAll
+	deadLetter.LastError = strings.Join(errs, "; ")
+	deadLetter.FailedAt = time.Now()
+
+	if err := p.deadLetters.save(&deadLetter); err != nil {
+		log.Printf("Error dead-lettering '%s': %s\n", delivery.ID, err)
+		return
+	}
+	log.Printf("Dead-lettered '%s' for upstreams %v\n", delivery.ID, redirectURLs)
+}
+
+// deadLetterHook dead-letters a hook which failed while being proxied synchronously
+func (p *Proxy) deadLetterHook(r *http.Request, hook *providers.Hook, policy string, results []targetResult) {
+	if p.deadLetters == nil {
+		return
+	}
+
+	id, err := newDeliveryID()
+	if err != nil {
+		log.Printf("Error creating delivery ID: %s", err)
+		return
+	}
+
+	redirectURLs := make([]string, len(results))
+	for i, result := range results {
+		redirectURLs[i] = result.URL
+	}
+
+	p.deadLetter(&Delivery{
+		ID:           id,
+		Hook:         hook,
+		RedirectURLs: redirectURLs,
+		Policy:       policy,
+		Source:       r.URL.String(),
+		AcceptedAt:   time.Now(),
+	}, results)
+}
+
+// replaceUpstream points redirectURL to upstream, keeping its path and query
+func replaceUpstream(redirectURL string, upstream string) (string, error) {
+	parsed, err := url.Parse(redirectURL)
+	if err != nil {
+		return "", err
+	}
+	return appendQuery(strings.TrimSuffix(upstream, "/")+parsed.EscapedPath(), parsed.RawQuery), nil
+}
+
+// replay delivers a dead-lettered delivery again, to upstream instead of the
+// original upstreams if it is set. The delivery leaves the dead-letter store
+// once it succeeded, otherwise its last error is updated.
+func (p *Proxy) replay(delivery *Delivery, upstream string) ([]targetResult, error) {
+	if len(upstream) > 0 {
+		for i, redirectURL := range delivery.RedirectURLs {
+			replaced, err := replaceUpstream(redirectURL, upstream)
+			if err != nil {
+				return nil, err
+			}
+			delivery.RedirectURLs[i] = replaced
+		}
+	}
+
+	p.restoreHeaders(delivery)
+	results := p.deliver(delivery)
+	if combinedStatusCode(delivery.Policy, results) < 400 {
+		return results, p.deadLetters.remove(delivery.ID)
+	}
+
+	p.deadLetter(delivery, results)
+	return results, nil
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(value)
+}
+
+func (p *Proxy) loadDeadLetter(w http.ResponseWriter, id string) (*Delivery, bool) {
+	delivery, err := p.deadLetters.load(id)
+	if os.IsNotExist(err) {
+		http.Error(w, "Dead letter '"+id+"' not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error loading dead letter '%s': %s\n", id, err)
+		http.Error(w, "Error loading dead letter '"+id+"'", http.StatusInternalServerError)
+		return nil, false
+	}
+	return delivery, true
+}
+
+// Lists the dead-lettered deliveries without their payloads
+func (p *Proxy) listDeadLetters(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	deliveries, err := p.deadLetters.list()
+	if err != nil {
+		log.Printf("Error listing dead letters: %s\n", err)
+		http.Error(w, "Error listing dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	summaries := make([]DeadLetterSummary, len(deliveries))
+	for i, delivery := range deliveries {
+		summaries[i] = summarize(delivery)
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+// Returns a dead-lettered delivery with its headers and payload
+func (p *Proxy) getDeadLetter(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	delivery, ok := p.loadDeadLetter(w, params.ByName("id"))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+// Replays a dead-lettered delivery, to the upstream query parameter if it is set
+func (p *Proxy) replayDeadLetter(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	delivery, ok := p.loadDeadLetter(w, params.ByName("id"))
+	if !ok {
+		return
+	}
+
+	upstream := r.URL.Query().Get("upstream")
+	if len(upstream) > 0 && len(p.allowedUpstreamHosts) > 0 && !isAllowedHost(p.allowedUpstreamHosts, upstream) {
+		http.Error(w, "Upstream host of '"+upstream+"' is not allowed", http.StatusForbidden)
+		return
+	}
+
+	results, err := p.replay(delivery, upstream)
+	if err != nil {
+		log.Printf("Error replaying dead letter '%s': %s\n", delivery.ID, err)
+		http.Error(w, "Error replaying dead letter '"+delivery.ID+"': "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	replayed := make([]ReplayResult, len(results))
+	for i, result := range results {
+		replayed[i] = ReplayResult{URL: result.URL, StatusCode: result.StatusCode}
+		if result.Err != nil {
+			replayed[i].Error = result.Err.Error()
+		}
+	}
+	writeJSON(w, combinedStatusCode(delivery.Policy, results), replayed)
+}
+
+// Purges one dead-lettered delivery
+func (p *Proxy) purgeDeadLetter(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	delivery, ok := p.loadDeadLetter(w, params.ByName("id"))
+	if !ok {
+		return
+	}
+	if err := p.deadLetters.remove(delivery.ID); err != nil {
+		log.Printf("Error purging dead letter '%s': %s\n", delivery.ID, err)
+		http.Error(w, "Error purging dead letter '"+delivery.ID+"'", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Purged dead letter '%s'\n", delivery.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Purges all dead-lettered deliveries
+func (p *Proxy) purgeDeadLetters(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	deliveries, err := p.deadLetters.list()
+	if err != nil {
+		log.Printf("Error listing dead letters: %s\n", err)
+		http.Error(w, "Error listing dead letters", http.StatusInternalServerError)
+		return
+	}
+	for _, delivery := range deliveries {
+		if err := p.deadLetters.remove(delivery.ID); err != nil {
+			log.Printf("Error purging dead letter '%s': %s\n", delivery.ID, err)
+			http.Error(w, "Error purging dead letter '"+delivery.ID+"'", http.StatusInternalServerError)
+			return
+		}
+	}
+	log.Printf("Purged %d dead letters\n", len(deliveries))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminRouter serves the admin endpoints, they are kept off the listener
+// receiving Webhooks as they must not be reachable by the Git provider
+func (p *Proxy) adminRouter() *httprouter.Router {
+	router := httprouter.New()
+	router.GET("/health", p.health)
+	router.GET("/status", p.status)
+	if p.deadLetters != nil {
+		router.GET("/deadletters", p.listDeadLetters)
+		router.DELETE("/deadletters", p.purgeDeadLetters)
+		router.GET("/deadletters/:id", p.getDeadLetter)
+		router.DELETE("/deadletters/:id", p.purgeDeadLetter)
+		router.POST("/deadletters/:id/replay", p.replayDeadLetter)
+	}
+	return router
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func createDeadLetterProxy(t *testing.T) (*Proxy, func()) {
+	dir, err := ioutil.TempDir("", "gwp-deadletters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetters, err := newDeliveryStore(dir, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return &Proxy{deadLetters: deadLetters}, func() { os.RemoveAll(dir) }
+}
+
+func TestFailedTargets(t *testing.T) {
+	ok := targetResult{URL: "http://a", StatusCode: http.StatusOK}
+	failed := targetResult{URL: "http://b", StatusCode: http.StatusBadGateway}
+	broken := targetResult{URL: "http://c", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name    string
+		policy  string
+		results []targetResult
+		want    []string
+	}{
+		{
+			name:    "TestFailedTargetsWithPolicyAll",
+			policy:  FanOutPolicyAll,
+			results: []targetResult{ok, failed, broken},
+			want:    []string{"http://b", "http://c"},
+		},
+		{
+			name:    "TestFailedTargetsWithPolicyPrimaryFailed",
+			policy:  FanOutPolicyPrimary,
+			results: []targetResult{failed, broken},
+			want:    []string{"http://b"},
+		},
+		{
+			name:    "TestFailedTargetsWithPolicyPrimarySucceeded",
+			policy:  FanOutPolicyPrimary,
+			results: []targetResult{ok, broken},
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, result := range failedTargets(tt.policy, tt.results) {
+				got = append(got, result.URL)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("failedTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceUpstream(t *testing.T) {
+	got, err := replaceUpstream("http://jenkins/github-webhook/?token=a", "https://standby/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://standby/github-webhook/?token=a"; got != want {
+		t.Errorf("replaceUpstream() = %v, want %v", got, want)
+	}
+}
+
+func TestProxy_deadLetter(t *testing.T) {
+	p, cleanup := createDeadLetterProxy(t)
+	defer cleanup()
+
+	delivery := createTestDelivery("failed", time.Now().UTC(), "http://a/post")
+	delivery.RedirectURLs = []string{"http://a/post", "http://b/post"}
+	p.deadLetter(delivery, []targetResult{
+		{URL: "http://a/post", StatusCode: http.StatusOK, Status: "200 OK"},
+		{URL: "http://b/post", StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"},
+	})
+
+	deadLetter, err := p.deadLetters.load("failed")
+	if err != nil {
+		t.Fatalf("deadLetters.load() error = %v", err)
+	}
+	if !reflect.DeepEqual(deadLetter.RedirectURLs, []string{"http://b/post"}) {
+		t.Errorf("dead letter RedirectURLs = %v, want only the failed upstream", deadLetter.RedirectURLs)
+	}
+	if want := "upstream 'http://b/post': 502 Bad Gateway"; deadLetter.LastError != want {
+		t.Errorf("dead letter LastError = %v, want %v", deadLetter.LastError, want)
+	}
+	if deadLetter.FailedAt.IsZero() {
+		t.Errorf("dead letter FailedAt is not set")
+	}
+	// The webhook secret is never written to disk
+	if want := p.deadLetters.stripped(delivery).Hook; !reflect.DeepEqual(deadLetter.Hook, want) {
+		t.Errorf("dead letter Hook = %v, want %v", deadLetter.Hook, want)
+	}
+}
+
+func TestProxy_adminRouterWithDeadLetters(t *testing.T) {
+	received := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Replays are signed again with the proxy's secret
+		if r.Header.Get(providers.XGitlabToken) != proxyGitlabTestSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received <- r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	p, cleanup := createDeadLetterProxy(t)
+	defer cleanup()
+	p.provider = providers.GitlabProviderKind
+	p.secret = proxyGitlabTestSecret
+	for _, id := range []string{"first", "second"} {
+		delivery := createTestDelivery(id, time.Now().UTC(), "http://127.0.0.1:1/post")
+		delivery.LastError = "connection refused"
+		if err := p.deadLetters.save(delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := p.adminRouter()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/deadletters", nil))
+	var summaries []DeadLetterSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &summaries); err != nil {
+		t.Fatalf("list returned invalid JSON %v: %v", rr.Body.String(), err)
+	}
+	if len(summaries) != 2 || summaries[0].LastError != "connection refused" {
+		t.Errorf("list returned %v, want both dead letters", summaries)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/deadletters/second", nil))
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), proxyGitlabTestSecret) {
+		t.Errorf("show returned %v with body %v, want %v without the secret", rr.Code, rr.Body.String(), http.StatusOK)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/deadletters/missing", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("show of missing dead letter returned %v, want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/deadletters/first/replay?upstream="+upstream.URL, nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("replay returned %v, want %v: %v", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	select {
+	case path := <-received:
+		if path != "/post" {
+			t.Errorf("replay was sent to path %v, want /post", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("upstream did not receive the replay")
+	}
+	if _, err := p.deadLetters.load("first"); !os.IsNotExist(err) {
+		t.Errorf("replayed dead letter was not removed")
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/deadletters", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("purge returned %v, want %v", rr.Code, http.StatusNoContent)
+	}
+	if deliveries, _ := p.deadLetters.list(); len(deliveries) != 0 {
+		t.Errorf("purge left %d dead letters", len(deliveries))
+	}
+}
+
+func TestProxy_proxyRequestWithDeadLettersAndOpenCircuit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	dir, err := ioutil.TempDir("", "gwp-deadletters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, proxyGitlabTestSecret, []string{},
+		WithDeadLetterDir(dir), WithCircuitBreaker(1, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.breakerFor(upstream.URL).record(false)
+	router := httprouter.New()
+	router.POST("/*path", p.proxyRequest)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, createGitlabRequest(http.MethodPost, "/post",
+		proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	// The Git provider retries the rejected hook itself
+	if deliveries, _ := p.deadLetters.list(); len(deliveries) != 0 {
+		t.Errorf("rejected hook was dead-lettered %d times", len(deliveries))
+	}
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"time"
+)
+
+// pushBatch collects the pushes to one ref during a debounce window
+type pushBatch struct {
+	// before is the SHA of the ref before the first push of the batch
+	before string
+	// latest is closed once a newer push supersedes the latest push
+	latest chan struct{}
+	// done is closed when the debounce window ends
+	done chan struct{}
+}
+
+// debounce holds a push to key, e.g. repository and ref, until the debounce
+// window started by the first push to key ends. Only the latest push of the
+// window is released, with the before SHA of the first push; earlier pushes
+// return false as soon as they are superseded.
+func (p *Proxy) debounce(key string, before string) (string, bool) {
+	p.debounceMutex.Lock()
+	if p.pushBatches == nil {
+		p.pushBatches = map[string]*pushBatch{}
+	}
+	batch, ok := p.pushBatches[key]
+	if !ok {
+		batch = &pushBatch{before: before, done: make(chan struct{})}
+		p.pushBatches[key] = batch
+		time.AfterFunc(p.debounceWindow, func() {
+			p.debounceMutex.Lock()
+			delete(p.pushBatches, key)
+			p.debounceMutex.Unlock()
+			close(batch.done)
+		})
+	}
+	if batch.latest != nil {
+		close(batch.latest)
+	}
+	latest := make(chan struct{})
+	batch.latest = latest
+	p.debounceMutex.Unlock()
+
+	select {
+	case <-latest:
+		return "", false
+	case <-batch.done:
+	}
+	// The batch is not changed anymore once its window ended
+	if batch.latest != latest {
+		return "", false
+	}
+	return batch.before, true
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+type debounceResult struct {
+	push   string
+	before string
+	latest bool
+}
+
+func TestProxy_debounce(t *testing.T) {
+	p := &Proxy{}
+	WithDebounce(100 * time.Millisecond)(p)
+
+	results := make(chan debounceResult, 4)
+	push := func(key string, push string, before string) {
+		got, latest := p.debounce(key, before)
+		results <- debounceResult{push: push, before: got, latest: latest}
+	}
+
+	go push("repo refs/heads/master", "b", "a")
+	time.Sleep(10 * time.Millisecond)
+	go push("repo refs/heads/master", "c", "b")
+	time.Sleep(10 * time.Millisecond)
+	go push("repo refs/heads/develop", "y", "x")
+	go push("repo refs/heads/master", "d", "c")
+
+	// The superseded push is released right away
+	if got := <-results; got.push != "b" || got.latest {
+		t.Errorf("first result = %+v, want superseded push b", got)
+	}
+
+	got := map[string]debounceResult{}
+	for i := 0; i < 3; i++ {
+		result := <-results
+		got[result.push] = result
+	}
+	if got["c"].latest {
+		t.Errorf("push c = %+v, want superseded", got["c"])
+	}
+	if want := (debounceResult{push: "d", before: "a", latest: true}); got["d"] != want {
+		t.Errorf("push d = %+v, want %+v", got["d"], want)
+	}
+	if want := (debounceResult{push: "y", before: "x", latest: true}); got["y"] != want {
+		t.Errorf("push y = %+v, want %+v", got["y"], want)
+	}
+
+	// A push after the window starts a new batch
+	if before, latest := p.debounce("repo refs/heads/master", "d"); !latest || before != "d" {
+		t.Errorf("debounce() after window = %v, %v, want d, true", before, latest)
+	}
+}
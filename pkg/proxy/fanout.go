@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+const (
+	// FanOutPolicyAll succeeds if every upstream accepted the hook
+	FanOutPolicyAll = "all"
+	// FanOutPolicyAny succeeds if at least one upstream accepted the hook
+	FanOutPolicyAny = "any"
+	// FanOutPolicyPrimary returns the result of the first upstream
+	FanOutPolicyPrimary = "primary"
+)
+
+// targetResult is the outcome of delivering a hook to one upstream
+type targetResult struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Err        error
+}
+
+func (r targetResult) succeeded() bool {
+	return r.Err == nil && r.StatusCode < 400
+}
+
+func (r targetResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("upstream '%s': error: %s", r.URL, r.Err)
+	}
+	return fmt.Sprintf("upstream '%s': %s", r.URL, r.Status)
+}
+
+// fanOut delivers the hook to all redirectURLs concurrently
+func (p *Proxy) fanOut(hook *providers.Hook, redirectURLs []string) []targetResult {
+	results := make([]targetResult, len(redirectURLs))
+
+	var wg sync.WaitGroup
+	for i, redirectURL := range redirectURLs {
+		wg.Add(1)
+		go func(i int, redirectURL string) {
+			defer wg.Done()
+			results[i] = targetResult{URL: redirectURL}
+
+			resp, err := p.redirect(hook, redirectURL)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			defer resp.Body.Close()
+			io.Copy(ioutil.Discard, resp.Body)
+
+			results[i].StatusCode = resp.StatusCode
+			results[i].Status = resp.Status
+		}(i, redirectURL)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// combinedStatusCode returns the status code reported to the Git provider for the results under policy
+func combinedStatusCode(policy string, results []targetResult) int {
+	switch policy {
+	case FanOutPolicyPrimary:
+		if results[0].Err != nil {
+			return http.StatusBadGateway
+		}
+		return results[0].StatusCode
+	case FanOutPolicyAny:
+		for _, result := range results {
+			if result.succeeded() {
+				return http.StatusOK
+			}
+		}
+		return http.StatusBadGateway
+	default:
+		for _, result := range results {
+			if !result.succeeded() {
+				return http.StatusBadGateway
+			}
+		}
+		return http.StatusOK
+	}
+}
+
+// proxyFanOut delivers the hook to several upstreams and writes the combined result
+func (p *Proxy) proxyFanOut(w http.ResponseWriter, r *http.Request, hook *providers.Hook,
+	redirectURLs []string, policy string) {
+	results := p.fanOut(hook, redirectURLs)
+	statusCode := combinedStatusCode(policy, results)
+	if statusCode >= 400 {
+		p.deadLetterHook(r, hook, policy, results)
+	}
+
+	lines := make([]string, len(results))
+	for i, result := range results {
+		log.Printf("Redirected incomming request '%s' to %s\n", r.URL, result)
+		lines[i] = result.String()
+	}
+
+	w.WriteHeader(statusCode)
+	w.Write([]byte(strings.Join(lines, "\n")))
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestProxy_fanOut(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ok.Close()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	p := &Proxy{}
+	hook := createGitlabHook(proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost)
+	results := p.fanOut(hook, []string{ok.URL + "/post", unavailable.URL + "/post"})
+
+	if len(results) != 2 {
+		t.Fatalf("Proxy.fanOut() got %v results, want 2", len(results))
+	}
+	if results[0].StatusCode != http.StatusOK || results[1].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Proxy.fanOut() got status codes %v and %v, want %v and %v", results[0].StatusCode,
+			results[1].StatusCode, http.StatusOK, http.StatusServiceUnavailable)
+	}
+}
+
+func TestCombinedStatusCode(t *testing.T) {
+	succeeded := targetResult{URL: "https://a.example.com", StatusCode: http.StatusOK, Status: "200 OK"}
+	failed := targetResult{URL: "https://b.example.com", StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+	errored := targetResult{URL: "https://c.example.com", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name    string
+		policy  string
+		results []targetResult
+		want    int
+	}{
+		{
+			name:    "TestCombinedStatusCodeWithAllSucceeded",
+			policy:  FanOutPolicyAll,
+			results: []targetResult{succeeded, succeeded},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "TestCombinedStatusCodeWithAllAndOneFailed",
+			policy:  "",
+			results: []targetResult{succeeded, errored},
+			want:    http.StatusBadGateway,
+		},
+		{
+			name:    "TestCombinedStatusCodeWithAnyAndOneSucceeded",
+			policy:  FanOutPolicyAny,
+			results: []targetResult{errored, succeeded},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "TestCombinedStatusCodeWithAnyAndNoneSucceeded",
+			policy:  FanOutPolicyAny,
+			results: []targetResult{errored, failed},
+			want:    http.StatusBadGateway,
+		},
+		{
+			name:    "TestCombinedStatusCodeWithPrimary",
+			policy:  FanOutPolicyPrimary,
+			results: []targetResult{failed, succeeded},
+			want:    http.StatusNotFound,
+		},
+		{
+			name:    "TestCombinedStatusCodeWithPrimaryError",
+			policy:  FanOutPolicyPrimary,
+			results: []targetResult{errored, succeeded},
+			want:    http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := combinedStatusCode(tt.policy, tt.results); got != tt.want {
+				t.Errorf("combinedStatusCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_proxyFanOut(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	p := &Proxy{}
+	hook := &providers.Hook{
+		Headers:       map[string]string{},
+		Payload:       []byte(proxyGitlabTestBody),
+		RequestMethod: http.MethodPost,
+	}
+	req := httptest.NewRequest(http.MethodPost, "/post", nil)
+	rr := httptest.NewRecorder()
+	p.proxyFanOut(rr, req, hook, []string{upstream.URL + "/a", upstream.URL + "/b"}, FanOutPolicyAll)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Proxy.proxyFanOut() got status code %v, want %v", rr.Code, http.StatusOK)
+	}
+	if lines := strings.Split(rr.Body.String(), "\n"); len(lines) != 2 {
+		t.Errorf("Proxy.proxyFanOut() got body %q, want one line per upstream", rr.Body.String())
+	}
+}
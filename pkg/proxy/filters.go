@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+// hasAllowedLabels checks the labels of a pull/merge request against the
+// required and ignored labels. Hooks which are not pull/merge requests are
+// always allowed.
+func (p *Proxy) hasAllowedLabels(pullRequest *providers.PullRequest) bool {
+	if pullRequest == nil {
+		return true
+	}
+
+	for _, label := range p.ignoredLabels {
+		if pullRequest.HasLabel(label) {
+			return false
+		}
+	}
+
+	for _, label := range p.requiredLabels {
+		if !pullRequest.HasLabel(label) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isTrustedFork checks whether a pull/merge request may be proxied under the
+// fork policy. Hooks which are not pull/merge requests from forks are always trusted.
+func (p *Proxy) isTrustedFork(pullRequest *providers.PullRequest) bool {
+	if pullRequest == nil || !pullRequest.Fork {
+		return true
+	}
+
+	if p.forkPolicy == "" || p.forkPolicy == ForkPolicyAllow {
+		return true
+	}
+
+	for _, association := range p.forkAllowedAssociations {
+		if strings.EqualFold(strings.TrimSpace(association), pullRequest.AuthorAssociation) {
+			return true
+		}
+	}
+
+	return len(strings.TrimSpace(p.forkApprovalLabel)) > 0 && pullRequest.HasLabel(p.forkApprovalLabel)
+}
+
+// isIgnoredDraft checks whether the hook is for a draft pull/merge request which should not be proxied
+func (p *Proxy) isIgnoredDraft(pullRequest *providers.PullRequest) bool {
+	return p.ignoreDrafts && pullRequest != nil && pullRequest.Draft
+}
+
+// matchesRepository checks the repository full name against glob patterns, ignoring case
+func matchesRepository(patterns []string, repository string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if matched, _ := path.Match(pattern, strings.ToLower(repository)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedRepository checks the repository of a hook against the allowed and denied repositories
+func (p *Proxy) isAllowedRepository(repository string) bool {
+	if matchesRepository(p.deniedRepositories, repository) {
+		return false
+	}
+
+	if len(p.allowedRepositories) > 0 {
+		return matchesRepository(p.allowedRepositories, repository)
+	}
+
+	return true
+}
+
+// userPattern returns the regex of a user entry written as /regex/
+func userPattern(user string) (string, bool) {
+	user = strings.TrimSpace(user)
+	if len(user) > 2 && strings.HasPrefix(user, "/") && strings.HasSuffix(user, "/") {
+		return user[1 : len(user)-1], true
+	}
+	return "", false
+}
+
+// matchesUser checks whether the user is in the list of users. Entries
+// written as /regex/ are matched as regular expressions, e.g. /\[bot\]$/
+func matchesUser(users []string, user string) bool {
+	for _, entry := range users {
+		if pattern, ok := userPattern(entry); ok {
+			if matched, _ := regexp.MatchString(pattern, user); matched {
+				return true
+			}
+			continue
+		}
+		if entry == user {
+			return true
+		}
+	}
+	return false
+}
+
+// isProxiedEvent checks whether the event is proxied. Github events other than
+// push, pull requests and issue comments were never proxied, so they are only
+// proxied to routes naming them in their events.
+func (p *Proxy) isProxiedEvent(event providers.Event) bool {
+	if p.provider != providers.GithubName {
+		return true
+	}
+	switch event {
+	case providers.GithubPushEvent, providers.GithubPullRequestEvent, providers.GithubIssueCommentEvent:
+		return true
+	}
+
+	for _, route := range p.routes {
+		if len(route.Events) > 0 && matchesAny(route.Events, string(event)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// HeaderPolicy configures which headers of the Git provider's request are
+// forwarded to the upstream in addition to the provider's own headers
+type HeaderPolicy struct {
+	// Forward are the request headers or globs forwarded, * forwards all
+	Forward []string `json:"forward"`
+	// Drop are the request headers or globs never forwarded
+	Drop []string `json:"drop"`
+	// Add are static headers set on every upstream request
+	Add map[string]string `json:"add"`
+	// Forwarded adds X-Forwarded-For, X-Forwarded-Proto and Forwarded headers
+	// describing the Git provider's request
+	Forwarded bool `json:"forwarded"`
+}
+
+// forwardedHeaders are computed by the proxy if the Forwarded policy is set
+var forwardedHeaders = []string{"X-Forwarded-For", "X-Forwarded-Proto", "Forwarded"}
+
+// proxyHeaders are set by the proxy only, e.g. the command headers, and are
+// never forwarded from the Git provider's request
+var proxyHeaders = []string{"X-Gwp-*"}
+
+func (h HeaderPolicy) validate() error {
+	for _, pattern := range append(append([]string{}, h.Forward...), h.Drop...) {
+		if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+			return errors.New("invalid header pattern '" + pattern + "'")
+		}
+	}
+	for name, value := range h.Add {
+		if len(name) == 0 || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return errors.New("invalid static header '" + name + "'")
+		}
+	}
+	return nil
+}
+
+// isForwardedHeader checks whether the request header name is forwarded
+// according to the header policy
+func (p *Proxy) isForwardedHeader(name string) bool {
+	if matchesHeader(hopByHopHeaders, name) || matchesHeader([]string{"Host"}, name) || matchesHeader(proxyHeaders, name) {
+		return false
+	}
+	if p.headerPolicy.Forwarded && matchesHeader(forwardedHeaders, name) {
+		return false
+	}
+	return matchesHeader(p.headerPolicy.Forward, name) && !matchesHeader(p.headerPolicy.Drop, name)
+}
+
+// setHeader sets the header name in headers, replacing a key differing only in case
+func setHeader(headers map[string]string, name string, value string) {
+	for existing := range headers {
+		if strings.EqualFold(existing, name) {
+			delete(headers, existing)
+		}
+	}
+	headers[name] = value
+}
+
+// forwardHeaders adds the request headers forwarded by the header policy, the
+// X-Forwarded headers and the static headers to the hook's headers. The
+// provider's headers already in headers are never replaced by request headers.
+func (p *Proxy) forwardHeaders(headers map[string]string, r *http.Request) {
+	providerHeaders := map[string]bool{}
+	for name := range headers {
+		providerHeaders[http.CanonicalHeaderKey(name)] = true
+	}
+
+	for name, values := range r.Header {
+		if providerHeaders[http.CanonicalHeaderKey(name)] || !p.isForwardedHeader(name) {
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	if p.headerPolicy.Forwarded {
+		addForwardedHeaders(headers, r)
+	}
+
+	for name, value := range p.headerPolicy.Add {
+		setHeader(headers, name, value)
+	}
+}
+
+// addForwardedHeaders appends the Git provider's address to the X-Forwarded-For
+// and Forwarded headers of the request, and sets X-Forwarded-Proto unless a
+// proxy in front already did
+func addForwardedHeaders(headers map[string]string, r *http.Request) {
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	forwardedFor := client
+	if previous := r.Header.Get("X-Forwarded-For"); len(previous) > 0 {
+		forwardedFor = previous + ", " + client
+	}
+	setHeader(headers, "X-Forwarded-For", forwardedFor)
+
+	forwardedProto := proto
+	if previous := r.Header.Get("X-Forwarded-Proto"); len(previous) > 0 {
+		forwardedProto = previous
+	}
+	setHeader(headers, "X-Forwarded-Proto", forwardedProto)
+
+	// IPv6 addresses are quoted and bracketed, see RFC 7239
+	node := client
+	if strings.Contains(client, ":") {
+		node = `"[` + client + `]"`
+	}
+	forwarded := "for=" + node + ";proto=" + proto
+	if len(r.Host) > 0 {
+		forwarded += `;host="` + r.Host + `"`
+	}
+	if previous := strings.Join(r.Header["Forwarded"], ", "); len(previous) > 0 {
+		forwarded = previous + ", " + forwarded
+	}
+	setHeader(headers, "Forwarded", forwarded)
+}
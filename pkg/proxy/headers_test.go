@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestProxy_forwardHeaders(t *testing.T) {
+	tests := []struct {
+		name          string
+		headerPolicy  HeaderPolicy
+		remoteAddr    string
+		requestHeader http.Header
+		want          map[string]string
+	}{
+		{
+			name:          "TestForwardHeadersWithoutPolicy",
+			requestHeader: http.Header{"User-Agent": {"GitLab/12.4.0"}},
+			want:          map[string]string{providers.XGitlabToken: "secret"},
+		},
+		{
+			name:         "TestForwardHeadersWithAllowlist",
+			headerPolicy: HeaderPolicy{Forward: []string{"User-Agent", "X-Gitlab-Instance"}},
+			requestHeader: http.Header{
+				"User-Agent":        {"GitLab/12.4.0"},
+				"X-Gitlab-Instance": {"https://gitlab.example.com"},
+				"Cookie":            {"session=secret"},
+			},
+			want: map[string]string{
+				providers.XGitlabToken: "secret",
+				"User-Agent":           "GitLab/12.4.0",
+				"X-Gitlab-Instance":    "https://gitlab.example.com",
+			},
+		},
+		{
+			name:         "TestForwardHeadersWithAllExcept",
+			headerPolicy: HeaderPolicy{Forward: []string{"*"}, Drop: []string{"Cookie"}},
+			requestHeader: http.Header{
+				"User-Agent":     {"GitLab/12.4.0"},
+				"Cookie":         {"session=secret"},
+				"Connection":     {"close"},
+				"Accept":         {"text/html", "application/json"},
+				"X-Gitlab-Token": {"other"},
+			},
+			want: map[string]string{
+				providers.XGitlabToken: "secret",
+				"User-Agent":           "GitLab/12.4.0",
+				"Accept":               "text/html, application/json",
+			},
+		},
+		{
+			name:         "TestForwardHeadersWithProxyHeaders",
+			headerPolicy: HeaderPolicy{Forward: []string{"*"}},
+			requestHeader: http.Header{
+				"User-Agent":      {"GitLab/12.4.0"},
+				XCommand:          {"deploy"},
+				XCommandArgs:      {"production"},
+				XCommandArg + "1": {"production"},
+				XDeliveryID:       {"forged"},
+			},
+			want: map[string]string{
+				providers.XGitlabToken: "secret",
+				"User-Agent":           "GitLab/12.4.0",
+			},
+		},
+		{
+			name:          "TestForwardHeadersWithStaticHeaders",
+			headerPolicy:  HeaderPolicy{Forward: []string{"*"}, Add: map[string]string{"user-agent": "gitwebhookproxy"}},
+			requestHeader: http.Header{"User-Agent": {"GitLab/12.4.0"}},
+			want: map[string]string{
+				providers.XGitlabToken: "secret",
+				"user-agent":           "gitwebhookproxy",
+			},
+		},
+		{
+			name:          "TestForwardHeadersWithForwardedHeaders",
+			headerPolicy:  HeaderPolicy{Forwarded: true},
+			remoteAddr:    "10.0.0.2:41234",
+			requestHeader: http.Header{},
+			want: map[string]string{
+				providers.XGitlabToken: "secret",
+				"X-Forwarded-For":      "10.0.0.2",
+				"X-Forwarded-Proto":    "http",
+				"Forwarded":            `for=10.0.0.2;proto=http;host="proxy.example.com"`,
+			},
+		},
+		{
+			name:         "TestForwardHeadersWithForwardedHeadersBehindProxy",
+			headerPolicy: HeaderPolicy{Forward: []string{"*"}, Forwarded: true},
+			remoteAddr:   "[fd00::2]:41234",
+			requestHeader: http.Header{
+				"X-Forwarded-For":   {"203.0.113.7"},
+				"X-Forwarded-Proto": {"https"},
+				"Forwarded":         {"for=203.0.113.7;proto=https"},
+			},
+			want: map[string]string{
+				providers.XGitlabToken: "secret",
+				"X-Forwarded-For":      "203.0.113.7, fd00::2",
+				"X-Forwarded-Proto":    "https",
+				"Forwarded":            `for=203.0.113.7;proto=https, for="[fd00::2]";proto=http;host="proxy.example.com"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{headerPolicy: tt.headerPolicy}
+			r := httptest.NewRequest(http.MethodPost, "http://proxy.example.com/post", nil)
+			r.Header = tt.requestHeader
+			if len(tt.remoteAddr) > 0 {
+				r.RemoteAddr = tt.remoteAddr
+			}
+
+			headers := map[string]string{providers.XGitlabToken: "secret"}
+			p.forwardHeaders(headers, r)
+			if !reflect.DeepEqual(headers, tt.want) {
+				t.Errorf("Proxy.forwardHeaders() = %v, want %v", headers, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewProxyWithInvalidHeaderPolicy(t *testing.T) {
+	for _, headerPolicy := range []HeaderPolicy{
+		{Forward: []string{"["}},
+		{Drop: []string{"X-[a"}},
+		{Add: map[string]string{"X-Source:": "gitwebhookproxy"}},
+		{Add: map[string]string{"X-Source": "gitwebhookproxy\r\nX-Injected: true"}},
+	} {
+		if _, err := NewProxy("http://localhost", []string{}, providers.GitlabProviderKind, "", []string{},
+			WithHeaderPolicy(headerPolicy)); err == nil {
+			t.Errorf("NewProxy() with header policy %+v error = nil, want an error", headerPolicy)
+		}
+	}
+}
+
+func TestProxy_proxyRequestWithHeaderPolicy(t *testing.T) {
+	var upstreamHeader http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeader = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, proxyGitlabTestSecret, []string{},
+		WithHeaderPolicy(HeaderPolicy{Forward: []string{"X-Gitlab-Instance"}, Forwarded: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := httprouter.New()
+	router.POST("/*path", p.proxyRequest)
+
+	req := createGitlabRequest(http.MethodPost, "/post", proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody)
+	req.Header.Set("X-Gitlab-Instance", "https://gitlab.example.com")
+	req.RemoteAddr = "10.0.0.2:41234"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	for name, want := range map[string]string{
+		providers.XGitlabToken: proxyGitlabTestSecret,
+		"X-Gitlab-Instance":    "https://gitlab.example.com",
+		"X-Forwarded-For":      "10.0.0.2",
+	} {
+		if got := upstreamHeader.Get(name); got != want {
+			t.Errorf("upstream received %v = %v, want %v", name, got, want)
+		}
+	}
+}
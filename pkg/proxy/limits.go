@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+const (
+	// LimitPolicyQueue waits up to the maximum wait for the upstream limits
+	LimitPolicyQueue = "queue"
+	// LimitPolicyReject fails requests exceeding the upstream limits immediately
+	LimitPolicyReject = "reject"
+)
+
+var (
+	errUpstreamRateLimited = errors.New("Upstream rate limit exceeded")
+	errUpstreamBusy        = errors.New("Upstream concurrency limit exceeded")
+)
+
+// upstreamLimits configures the requests in flight and per second to each upstream
+type upstreamLimits struct {
+	maxConcurrent     int
+	requestsPerSecond float64
+	burst             int
+	policy            string
+	maxWait           time.Duration
+}
+
+func (l upstreamLimits) enabled() bool {
+	return l.maxConcurrent > 0 || l.requestsPerSecond > 0
+}
+
+// upstreamLimiter enforces the limits of one upstream with a semaphore for
+// the requests in flight and a token bucket for the requests per second
+type upstreamLimiter struct {
+	limits upstreamLimits
+	slots  chan struct{}
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newUpstreamLimiter(limits upstreamLimits) *upstreamLimiter {
+	limiter := &upstreamLimiter{
+		limits: limits,
+		tokens: float64(limits.burst),
+		last:   time.Now(),
+	}
+	if limits.maxConcurrent > 0 {
+		limiter.slots = make(chan struct{}, limits.maxConcurrent)
+	}
+	return limiter
+}
+
+// reserveToken takes a token from the bucket and returns how long to wait
+// for it, it fails if that is longer than maxWait
+func (l *upstreamLimiter) reserveToken(maxWait time.Duration) (time.Duration, error) {
+	if l.limits.requestsPerSecond <= 0 {
+		return 0, nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.limits.requestsPerSecond
+	if burst := float64(l.limits.burst); l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+
+	var wait time.Duration
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.limits.requestsPerSecond * float64(time.Second))
+	}
+	if wait > maxWait {
+		return 0, errUpstreamRateLimited
+	}
+	// Tokens may go negative, later requests then wait for this reservation as well
+	l.tokens--
+	return wait, nil
+}
+
+// acquire blocks until the request may be sent to the upstream, waiting at
+// most maxWait in total. The returned function releases the request's slot.
+func (l *upstreamLimiter) acquire() (func(), error) {
+	maxWait := l.limits.maxWait
+	if l.limits.policy == LimitPolicyReject {
+		maxWait = 0
+	}
+	deadline := time.Now().Add(maxWait)
+
+	wait, err := l.reserveToken(maxWait)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(wait)
+
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return nil, errUpstreamBusy
+	}
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, errUpstreamBusy
+	}
+}
+
+// limiterFor returns the limiter of the upstream of redirectURL, nil if
+// upstream limits are disabled
+func (p *Proxy) limiterFor(redirectURL string) *upstreamLimiter {
+	if !p.limits.enabled() {
+		return nil
+	}
+
+	key := upstreamKey(redirectURL)
+	p.limitersMutex.Lock()
+	defer p.limitersMutex.Unlock()
+	if p.limiters == nil {
+		p.limiters = map[string]*upstreamLimiter{}
+	}
+	limiter, ok := p.limiters[key]
+	if !ok {
+		limiter = newUpstreamLimiter(p.limits)
+		p.limiters[key] = limiter
+	}
+	return limiter
+}
+
+// redirectWithinLimits sends the hook to redirectURL once the limits of its
+// upstream allow it. The request's slot is held until the response body is
+// closed, so responses streamed to the Git provider count as in flight.
+func (p *Proxy) redirectWithinLimits(hook *providers.Hook, redirectURL string) (*http.Response, error) {
+	limiter := p.limiterFor(redirectURL)
+	if limiter == nil {
+		return p.redirectOnce(hook, redirectURL)
+	}
+
+	release, err := limiter.acquire()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.redirectOnce(hook, redirectURL)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody releases the slot of its request once it is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// limitStatusCode returns the status code reported to the Git provider when
+// err is caused by the upstream limits or an open circuit, zero otherwise
+func limitStatusCode(err error) int {
+	switch err {
+	case errUpstreamRateLimited:
+		return http.StatusTooManyRequests
+	case errUpstreamBusy, errCircuitOpen:
+		return http.StatusServiceUnavailable
+	}
+	return 0
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestUpstreamLimiter_acquire(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   upstreamLimits
+		acquired int
+		wantErr  error
+	}{
+		{
+			name:     "TestUpstreamLimiterWithConcurrencyLimitRejected",
+			limits:   upstreamLimits{maxConcurrent: 2, policy: LimitPolicyReject},
+			acquired: 2,
+			wantErr:  errUpstreamBusy,
+		},
+		{
+			name:     "TestUpstreamLimiterWithConcurrencyLimitTimedOut",
+			limits:   upstreamLimits{maxConcurrent: 1, policy: LimitPolicyQueue, maxWait: 10 * time.Millisecond},
+			acquired: 1,
+			wantErr:  errUpstreamBusy,
+		},
+		{
+			name:     "TestUpstreamLimiterWithRateLimitRejected",
+			limits:   upstreamLimits{requestsPerSecond: 1, burst: 2, policy: LimitPolicyReject},
+			acquired: 2,
+			wantErr:  errUpstreamRateLimited,
+		},
+		{
+			name:     "TestUpstreamLimiterWithRateLimitQueued",
+			limits:   upstreamLimits{requestsPerSecond: 50, burst: 1, policy: LimitPolicyQueue, maxWait: time.Second},
+			acquired: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newUpstreamLimiter(tt.limits)
+			for i := 0; i < tt.acquired; i++ {
+				if _, err := limiter.acquire(); err != nil {
+					t.Fatalf("acquire() %d error = %v", i, err)
+				}
+			}
+			if _, err := limiter.acquire(); err != tt.wantErr {
+				t.Errorf("acquire() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpstreamLimiter_acquireAfterRelease(t *testing.T) {
+	limiter := newUpstreamLimiter(upstreamLimits{maxConcurrent: 1, policy: LimitPolicyQueue, maxWait: time.Second})
+	release, err := limiter.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(10*time.Millisecond, release)
+
+	if _, err := limiter.acquire(); err != nil {
+		t.Errorf("acquire() error = %v, want the released slot", err)
+	}
+}
+
+func TestProxy_proxyRequestWithUpstreamLimits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, proxyGitlabTestSecret, []string{},
+		WithUpstreamLimits(0, 1, 1, LimitPolicyReject, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := httprouter.New()
+	router.POST("/*path", p.proxyRequest)
+
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, createGitlabRequest(http.MethodPost, "/post",
+			proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody))
+		if rr.Code != want {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, want)
+		}
+	}
+}
+
+func TestNewProxyWithInvalidUpstreamLimits(t *testing.T) {
+	for _, option := range []Option{
+		WithUpstreamLimits(-1, 0, 1, LimitPolicyQueue, 0),
+		WithUpstreamLimits(0, 1, 0, LimitPolicyQueue, 0),
+		WithUpstreamLimits(1, 0, 1, "drop", 0),
+	} {
+		if _, err := NewProxy("http://localhost", []string{}, providers.GitlabProviderKind, "", []string{}, option); err == nil {
+			t.Errorf("NewProxy() error = nil, want an error for invalid upstream limits")
+		}
+	}
+}
+
+func TestProxy_redirectWithLimitsUntilBodyClosed(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(proxyGitlabTestBody))
+	}))
+	defer upstream.Close()
+
+	p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, "", []string{},
+		WithUpstreamLimits(1, 0, 1, LimitPolicyReject, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := createGitlabHook("", proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost)
+
+	resp, err := p.redirect(hook, upstream.URL+"/post")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first response is still streamed to the Git provider
+	if _, err := p.redirect(hook, upstream.URL+"/post"); err != errUpstreamBusy {
+		t.Errorf("redirect() while a response body is open error = %v, want %v", err, errUpstreamBusy)
+	}
+
+	resp.Body.Close()
+	resp, err = p.redirect(hook, upstream.URL+"/post")
+	if err != nil {
+		t.Fatalf("redirect() after the response body was closed error = %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestProxy_redirectWithLimitsAndOpenCircuit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, "", []string{},
+		WithUpstreamLimits(0, 0.001, 1, LimitPolicyReject, 0), WithCircuitBreaker(1, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := createGitlabHook("", proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost)
+	breaker := p.breakerFor(upstream.URL)
+	breaker.record(false)
+
+	if _, err := p.redirect(hook, upstream.URL+"/post"); err != errCircuitOpen {
+		t.Fatalf("redirect() error = %v, want %v", err, errCircuitOpen)
+	}
+
+	// Failing fast on the open circuit didn't use up the only token
+	breaker.record(true)
+	resp, err := p.redirect(hook, upstream.URL+"/post")
+	if err != nil {
+		t.Fatalf("redirect() after the circuit closed error = %v", err)
+	}
+	resp.Body.Close()
+}
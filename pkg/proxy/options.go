@@ -0,0 +1,252 @@
+package proxy
+
+import "time"
+
+const (
+	// ForkPolicyAllow proxies pull requests from forks like any other
+	ForkPolicyAllow = "allow"
+	// ForkPolicyBlock rejects untrusted pull requests from forks
+	ForkPolicyBlock = "block"
+	// ForkPolicyHold acknowledges untrusted pull requests from forks without
+	// proxying them until they are approved with a label
+	ForkPolicyHold = "hold"
+)
+
+// Option configures optional Proxy behaviour in NewProxy
+type Option func(*Proxy)
+
+// WithRequiredLabels only proxies pull/merge request events carrying all of the given labels
+func WithRequiredLabels(labels []string) Option {
+	return func(p *Proxy) {
+		p.requiredLabels = labels
+	}
+}
+
+// WithIgnoredLabels drops pull/merge request events carrying any of the given labels
+func WithIgnoredLabels(labels []string) Option {
+	return func(p *Proxy) {
+		p.ignoredLabels = labels
+	}
+}
+
+// WithForkPolicy sets how pull/merge requests from forks are handled. Forks are
+// trusted if the author association is one of allowedAssociations or the pull
+// request carries approvalLabel.
+func WithForkPolicy(policy string, allowedAssociations []string, approvalLabel string) Option {
+	return func(p *Proxy) {
+		p.forkPolicy = policy
+		p.forkAllowedAssociations = allowedAssociations
+		p.forkApprovalLabel = approvalLabel
+	}
+}
+
+// WithCommands proxies comments matching one of the commands to the command's upstream path
+func WithCommands(commands []Command) Option {
+	return func(p *Proxy) {
+		p.commands = commands
+	}
+}
+
+// WithDraftPolicy drops events of draft pull/merge requests if ignoreDrafts is
+// set. When readyForReviewAction is set, the action of a draft marked as ready
+// for review is replaced with it, e.g. "opened", so upstreams build it.
+func WithDraftPolicy(ignoreDrafts bool, readyForReviewAction string) Option {
+	return func(p *Proxy) {
+		p.ignoreDrafts = ignoreDrafts
+		p.readyForReviewAction = readyForReviewAction
+	}
+}
+
+// WithRepositories restricts the repositories whose hooks are proxied. Both
+// lists hold glob patterns matched against the repository full name, e.g. platform/*
+func WithRepositories(allowedRepositories []string, deniedRepositories []string) Option {
+	return func(p *Proxy) {
+		p.allowedRepositories = allowedRepositories
+		p.deniedRepositories = deniedRepositories
+	}
+}
+
+// WithAllowedUsers only proxies hooks of the given users. Entries written as /regex/ are matched as regular expressions
+func WithAllowedUsers(users []string) Option {
+	return func(p *Proxy) {
+		p.allowedUsers = users
+	}
+}
+
+// WithIgnoreBots drops hooks sent by bots, e.g. dependabot[bot] or Gitlab project bot users
+func WithIgnoreBots(ignoreBots bool) Option {
+	return func(p *Proxy) {
+		p.ignoreBots = ignoreBots
+	}
+}
+
+// WithRoutes proxies hooks to the upstream of the first matching route
+// instead of the default upstreamURL. The hosts of templated upstream URLs
+// must match one of allowedUpstreamHosts.
+func WithRoutes(routes []Route, allowedUpstreamHosts []string) Option {
+	return func(p *Proxy) {
+		p.routes = routes
+		p.allowedUpstreamHosts = allowedUpstreamHosts
+	}
+}
+
+// WithAsyncDelivery acknowledges hooks with 202 Accepted as soon as they are
+// validated and delivers them in the background with a pool of workers. At
+// most queueSize hooks wait for delivery, further hooks are rejected.
+func WithAsyncDelivery(workers int, queueSize int) Option {
+	return func(p *Proxy) {
+		p.workers = workers
+		p.queueSize = queueSize
+	}
+}
+
+// WithRetries retries upstream requests failing with a network error or a
+// 502, 503, 504 or 429 status up to maxRetries times. The interval between
+// attempts doubles from initialInterval up to maxInterval, a Retry-After
+// header of the upstream takes precedence. No retry is started once
+// maxElapsedTime would be exceeded.
+func WithRetries(maxRetries int, initialInterval time.Duration, maxInterval time.Duration,
+	maxElapsedTime time.Duration) Option {
+	return func(p *Proxy) {
+		p.retry = retryPolicy{
+			maxRetries:      maxRetries,
+			initialInterval: initialInterval,
+			maxInterval:     maxInterval,
+			maxElapsedTime:  maxElapsedTime,
+		}
+	}
+}
+
+// WithQueueDir stores hooks accepted for async delivery in dir until they are
+// delivered successfully, and resumes their delivery when the Proxy starts
+func WithQueueDir(dir string) Option {
+	return func(p *Proxy) {
+		p.queueDir = dir
+	}
+}
+
+// WithDeadLetterDir stores hooks which could not be delivered once their
+// retries are exhausted in dir, with the upstreams they failed for and the
+// last error, so they can be inspected, replayed or purged later
+func WithDeadLetterDir(dir string) Option {
+	return func(p *Proxy) {
+		p.deadLetterDir = dir
+	}
+}
+
+// WithAdminListenAddress serves the admin endpoints, e.g. to manage dead
+// letters, on a separate listenAddress
+func WithAdminListenAddress(listenAddress string) Option {
+	return func(p *Proxy) {
+		p.adminListenAddress = listenAddress
+	}
+}
+
+// WithCircuitBreaker stops sending requests to an upstream host after
+// failureThreshold consecutive network errors or 5xx responses. While the
+// circuit is open requests fail fast, or stay queued in async mode. After
+// openDuration a single probe request decides whether the circuit closes.
+func WithCircuitBreaker(failureThreshold int, openDuration time.Duration) Option {
+	return func(p *Proxy) {
+		p.circuitFailureThreshold = failureThreshold
+		p.circuitOpenDuration = openDuration
+	}
+}
+
+// WithUpstreamLimits limits the requests in flight to each upstream host to
+// maxConcurrent and the requests per second to requestsPerSecond, allowing
+// bursts of burst requests; zero disables a limit. With LimitPolicyQueue
+// requests wait up to maxWait for the limits, with LimitPolicyReject they
+// fail immediately with 429 or 503.
+func WithUpstreamLimits(maxConcurrent int, requestsPerSecond float64, burst int, policy string,
+	maxWait time.Duration) Option {
+	return func(p *Proxy) {
+		p.limits = upstreamLimits{
+			maxConcurrent:     maxConcurrent,
+			requestsPerSecond: requestsPerSecond,
+			burst:             burst,
+			policy:            policy,
+			maxWait:           maxWait,
+		}
+	}
+}
+
+// WithDebounce holds push events for window after the first push to a
+// repository and ref, and forwards only the latest push of the window with
+// the before SHA of the first one. Superseded pushes are answered with 202
+// Accepted.
+func WithDebounce(window time.Duration) Option {
+	return func(p *Proxy) {
+		p.debounceWindow = window
+	}
+}
+
+// WithOrdering delivers hooks sharing a key one at a time in arrival order,
+// the key is the repository with OrderingRepository or the repository and
+// ref with OrderingRef. Hooks with different keys are delivered in parallel.
+func WithOrdering(ordering string) Option {
+	return func(p *Proxy) {
+		p.ordering = ordering
+	}
+}
+
+// WithResponseHeaders passes the headers of the upstream response matching
+// passed, e.g. Location or X-Jenkins-*, through to the Git provider unless
+// they match denied. If only denied is set, all other headers are passed.
+func WithResponseHeaders(passed []string, denied []string) Option {
+	return func(p *Proxy) {
+		p.passedResponseHeaders = passed
+		p.deniedResponseHeaders = denied
+	}
+}
+
+// WithHideUpstreamBody stops echoing the body of the upstream response to the Git provider
+func WithHideUpstreamBody(hideUpstreamBody bool) Option {
+	return func(p *Proxy) {
+		p.hideUpstreamBody = hideUpstreamBody
+	}
+}
+
+// WithMaxPayloadSize rejects hooks with a payload larger than maxPayloadSize
+// bytes with 413 Request Entity Too Large, there is no limit if it is 0
+func WithMaxPayloadSize(maxPayloadSize int64) Option {
+	return func(p *Proxy) {
+		p.maxPayloadSize = maxPayloadSize
+	}
+}
+
+// WithUpstreamTLS configures the TLS connections to the upstreams. The first
+// configuration matching the upstream host is used; upstreams matching none
+// verify certificates against the system CAs.
+func WithUpstreamTLS(upstreamTLS []UpstreamTLS) Option {
+	return func(p *Proxy) {
+		p.upstreamTLS = upstreamTLS
+	}
+}
+
+// WithUpstreamSecrets signs hooks again with the secret of the first upstream
+// secret matching the upstream host before they are sent, so each hop has its
+// own secret: Github hooks get new X-Hub-Signature and X-Hub-Signature-256
+// headers, Gitlab hooks a new X-Gitlab-Token
+func WithUpstreamSecrets(upstreamSecrets []UpstreamSecret) Option {
+	return func(p *Proxy) {
+		p.upstreamSecrets = upstreamSecrets
+	}
+}
+
+// WithUpstreamAuth adds the credentials of the first upstream auth matching
+// the upstream host to the requests sent to it
+func WithUpstreamAuth(upstreamAuth []UpstreamAuth) Option {
+	return func(p *Proxy) {
+		p.upstreamAuth = upstreamAuth
+	}
+}
+
+// WithHeaderPolicy forwards the Git provider's request headers matching the
+// policy to the upstream in addition to the provider's headers, and adds
+// X-Forwarded and static headers
+func WithHeaderPolicy(headerPolicy HeaderPolicy) Option {
+	return func(p *Proxy) {
+		p.headerPolicy = headerPolicy
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"sync"
+)
+
+const (
+	// OrderingRepository delivers hooks of the same repository one at a time
+	OrderingRepository = "repository"
+	// OrderingRef delivers hooks of the same repository and ref one at a time
+	OrderingRef = "ref"
+)
+
+// sequencer runs work sharing a key one at a time in arrival order, while
+// work with different keys runs in parallel. The arrival order is fixed by
+// the tickets taken with reserve, not by when run is called, so work handed
+// over between goroutines can't overtake earlier work.
+type sequencer struct {
+	mutex sync.Mutex
+	keys  map[string]*sequence
+}
+
+// sequence is the state of the work sharing a key
+type sequence struct {
+	// issued is the number of tickets taken, next the ticket which runs next
+	issued uint64
+	next   uint64
+	// ready is the work waiting for its ticket's turn
+	ready   map[uint64]func()
+	running bool
+}
+
+// reserve takes the next ticket of key, which must be passed to run exactly once
+func (s *sequencer) reserve(key string) uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.keys == nil {
+		s.keys = map[string]*sequence{}
+	}
+	seq, ok := s.keys[key]
+	if !ok {
+		seq = &sequence{ready: map[uint64]func(){}}
+		s.keys[key] = seq
+	}
+	ticket := seq.issued
+	seq.issued++
+	return ticket
+}
+
+// run calls work once the work of all earlier tickets of key finished. If
+// that's not the case yet work is kept and run by the goroutine finishing
+// the work before it, so run never blocks on other work. A nil work skips the
+// ticket.
+func (s *sequencer) run(key string, ticket uint64, work func()) {
+	if work == nil {
+		work = func() {}
+	}
+
+	s.mutex.Lock()
+	seq := s.keys[key]
+	seq.ready[ticket] = work
+	if seq.running {
+		s.mutex.Unlock()
+		return
+	}
+	seq.running = true
+
+	for {
+		work, ok := seq.ready[seq.next]
+		if !ok {
+			break
+		}
+		delete(seq.ready, seq.next)
+		seq.next++
+		s.mutex.Unlock()
+		work()
+		s.mutex.Lock()
+	}
+
+	seq.running = false
+	if seq.next == seq.issued {
+		delete(s.keys, key)
+	}
+	s.mutex.Unlock()
+}
+
+// wait blocks until the work of all earlier tickets of key finished, the
+// returned function must be called once the caller's own work finished
+func (s *sequencer) wait(key string, ticket uint64) func() {
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	go s.run(key, ticket, func() {
+		close(started)
+		<-finished
+	})
+	<-started
+	return func() { close(finished) }
+}
+
+// orderingKey returns the key of hooks which have to be delivered in order,
+// empty if the hook can be delivered in parallel to all others
+func (p *Proxy) orderingKey(info HookInfo) string {
+	if len(info.Repository) == 0 {
+		return ""
+	}
+	switch p.ordering {
+	case OrderingRepository:
+		return info.Repository
+	case OrderingRef:
+		return info.Repository + " " + info.Ref
+	}
+	return ""
+}
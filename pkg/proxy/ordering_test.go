@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestSequencer_run(t *testing.T) {
+	s := &sequencer{}
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	order := []string{}
+	var wg sync.WaitGroup
+	record := func(name string) func() {
+		wg.Add(1)
+		return func() {
+			mutex.Lock()
+			order = append(order, name)
+			mutex.Unlock()
+			wg.Done()
+		}
+	}
+
+	a1, a2, a3, a4 := s.reserve("a"), s.reserve("a"), s.reserve("a"), s.reserve("a")
+	b1 := s.reserve("b")
+
+	// Work handed in before its turn returns right away and runs in ticket order
+	s.run("a", a3, record("a3"))
+	s.run("a", a2, record("a2"))
+	// A skipped ticket doesn't hold back later work
+	s.run("a", a4, nil)
+
+	started := make(chan struct{})
+	first := record("a1")
+	go s.run("a", a1, func() {
+		close(started)
+		<-release
+		first()
+	})
+	<-started
+
+	// Work with another key is not held back
+	s.run("b", b1, record("b1"))
+
+	close(release)
+	wg.Wait()
+
+	if want := []string{"b1", "a1", "a2", "a3"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("sequencer ran work in order %v, want %v", order, want)
+	}
+
+	// The last work's goroutine releases the key right after the work returned
+	for i := 0; ; i++ {
+		s.mutex.Lock()
+		keys := len(s.keys)
+		s.mutex.Unlock()
+		if keys == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("sequencer kept %d keys after all work finished", keys)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSequencer_wait(t *testing.T) {
+	s := &sequencer{}
+	first, second := s.reserve("a"), s.reserve("a")
+
+	acquired := make(chan struct{})
+	go func() {
+		s.wait("a", second)()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("wait() returned before earlier work with the same key started")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	done := s.wait("a", first)
+	select {
+	case <-acquired:
+		t.Fatalf("wait() returned while earlier work with the same key was running")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	done()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Errorf("wait() did not return after earlier work finished")
+	}
+}
+
+func TestProxy_proxyAsyncWithOrdering(t *testing.T) {
+	const deliveries = 20
+	var mutex sync.Mutex
+	received := []string{}
+	done := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		received = append(received, string(body))
+		if len(received) == deliveries {
+			close(done)
+		}
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, "", []string{},
+		WithOrdering(OrderingRepository))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.deliveries = newDeliveryQueue(deliveries)
+
+	// Alternating priorities make the workers take the deliveries out of
+	// their arrival order
+	want := make([]string, deliveries)
+	for i := range want {
+		want[i] = fmt.Sprint(i)
+		priority := PriorityLow
+		if i%2 == 1 {
+			priority = PriorityHigh
+		}
+		hook := createGitlabHook("", proxyGitlabTestEvent, want[i], http.MethodPost)
+		rr := httptest.NewRecorder()
+		p.proxyAsync(rr, httptest.NewRequest(http.MethodPost, "/post", nil), hook,
+			[]string{upstream.URL + "/post"}, FanOutPolicyAll, "stakater/GitWebhookProxy", priority)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("proxyAsync() returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		go p.worker()
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("upstream did not receive all deliveries")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("upstream received deliveries in order %v, want %v", received, want)
+	}
+}
+
+func TestProxy_orderingKey(t *testing.T) {
+	info := HookInfo{Repository: "stakater/GitWebhookProxy", Ref: "refs/heads/master"}
+	tests := []struct {
+		name     string
+		ordering string
+		info     HookInfo
+		want     string
+	}{
+		{
+			name: "TestOrderingKeyWithoutOrdering",
+			info: info,
+			want: "",
+		},
+		{
+			name:     "TestOrderingKeyWithRepositoryOrdering",
+			ordering: OrderingRepository,
+			info:     info,
+			want:     "stakater/GitWebhookProxy",
+		},
+		{
+			name:     "TestOrderingKeyWithRefOrdering",
+			ordering: OrderingRef,
+			info:     info,
+			want:     "stakater/GitWebhookProxy refs/heads/master",
+		},
+		{
+			name:     "TestOrderingKeyWithoutRepository",
+			ordering: OrderingRef,
+			info:     HookInfo{Ref: "refs/heads/master"},
+			want:     "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{ordering: tt.ordering}
+			if got := p.orderingKey(tt.info); got != tt.want {
+				t.Errorf("Proxy.orderingKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
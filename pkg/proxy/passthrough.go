@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// hopByHopHeaders describe a single connection and are never passed through
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length",
+}
+
+// bodyHeaders describe the upstream response body and are dropped with it
+var bodyHeaders = []string{"Content-Type", "Content-Encoding"}
+
+// matchesHeader checks name against a list of header names or globs, e.g. X-Jenkins-*, ignoring case
+func matchesHeader(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if matched, _ := path.Match(pattern, strings.ToLower(name)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isPassedHeader checks whether the upstream response header name is passed
+// through to the Git provider. Without an allowlist all headers which are not
+// denied are passed, without both lists no header is passed.
+func (p *Proxy) isPassedHeader(name string) bool {
+	if len(p.passedResponseHeaders) == 0 && len(p.deniedResponseHeaders) == 0 {
+		return false
+	}
+	if matchesHeader(hopByHopHeaders, name) || matchesHeader(p.deniedResponseHeaders, name) {
+		return false
+	}
+	if p.hideUpstreamBody && matchesHeader(bodyHeaders, name) {
+		return false
+	}
+	return len(p.passedResponseHeaders) == 0 || matchesHeader(p.passedResponseHeaders, name)
+}
+
+// copyResponseHeaders copies the passed through headers of the upstream response to w
+func (p *Proxy) copyResponseHeaders(w http.ResponseWriter, resp *http.Response) {
+	for name, values := range resp.Header {
+		if !p.isPassedHeader(name) {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+}
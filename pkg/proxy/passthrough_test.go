@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestProxy_isPassedHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		passed []string
+		denied []string
+		header string
+		want   bool
+	}{
+		{
+			name:   "TestIsPassedHeaderWithoutLists",
+			header: "Content-Type",
+			want:   false,
+		},
+		{
+			name:   "TestIsPassedHeaderWithAllowedGlob",
+			passed: []string{"x-jenkins-*"},
+			header: "X-Jenkins-Session",
+			want:   true,
+		},
+		{
+			name:   "TestIsPassedHeaderNotAllowed",
+			passed: []string{"Location"},
+			header: "Server",
+			want:   false,
+		},
+		{
+			name:   "TestIsPassedHeaderWithAllowedAndDenied",
+			passed: []string{"X-Jenkins-*"},
+			denied: []string{"X-Jenkins-Session"},
+			header: "X-Jenkins-Session",
+			want:   false,
+		},
+		{
+			name:   "TestIsPassedHeaderWithOnlyDenied",
+			denied: []string{"Set-Cookie"},
+			header: "Location",
+			want:   true,
+		},
+		{
+			name:   "TestIsPassedHeaderWithHopByHopHeader",
+			passed: []string{"*"},
+			header: "Transfer-Encoding",
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{passedResponseHeaders: tt.passed, deniedResponseHeaders: tt.denied}
+			if got := p.isPassedHeader(tt.header); got != tt.want {
+				t.Errorf("Proxy.isPassedHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_proxyRequestWithResponseHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("X-Jenkins", "2.190")
+		w.Header().Set("X-Jenkins-Session", "secret")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("<html>internal details</html>"))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name             string
+		hideUpstreamBody bool
+		wantContentType  string
+		wantBody         string
+	}{
+		{
+			name:            "TestProxyRequestWithResponseHeaders",
+			wantContentType: "text/html",
+			wantBody:        "<html>internal details</html>",
+		},
+		{
+			name:             "TestProxyRequestWithHiddenUpstreamBody",
+			hideUpstreamBody: true,
+			wantContentType:  "",
+			wantBody:         "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, proxyGitlabTestSecret, []string{},
+				WithResponseHeaders([]string{"Content-Type", "X-Jenkins*"}, []string{"X-Jenkins-Session"}),
+				WithHideUpstreamBody(tt.hideUpstreamBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router := httprouter.New()
+			router.POST("/*path", p.proxyRequest)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, createGitlabRequest(http.MethodPost, "/post",
+				proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody))
+
+			if rr.Code != http.StatusCreated {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+			}
+			if got := rr.Header().Get("X-Jenkins"); got != "2.190" {
+				t.Errorf("handler returned X-Jenkins header %v, want %v", got, "2.190")
+			}
+			if got := rr.Header().Get("X-Jenkins-Session"); got != "" {
+				t.Errorf("handler passed denied X-Jenkins-Session header %v", got)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("handler returned Content-Type %v, want %v", got, tt.wantContentType)
+			}
+			if got := rr.Body.String(); got != tt.wantBody {
+				t.Errorf("handler returned body %v, want %v", got, tt.wantBody)
+			}
+		})
+	}
+}
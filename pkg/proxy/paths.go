@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Path match modes, written as a prefix of an allowed path entry, e.g. exact:/github-webhook/
+const (
+	// PathMatchExact matches the path exactly
+	PathMatchExact = "exact:"
+	// PathMatchPrefix matches the path and every path below it, /project
+	// matches /project/app but not /projectx
+	PathMatchPrefix = "prefix:"
+	// PathMatchRegex matches the path against a regular expression
+	PathMatchRegex = "regex:"
+)
+
+// regexMetaCharacters are not expected in plain paths, an entry containing
+// them is ambiguous between a path and a regex
+const regexMetaCharacters = `^$*+?()[]{}|\`
+
+var (
+	pathRegexes     = map[string]*regexp.Regexp{}
+	pathRegexesLock sync.Mutex
+)
+
+// pathRegex returns the compiled regex for pattern, compiling each pattern only once
+func pathRegex(pattern string) (*regexp.Regexp, error) {
+	pathRegexesLock.Lock()
+	defer pathRegexesLock.Unlock()
+
+	if regex, ok := pathRegexes[pattern]; ok {
+		return regex, nil
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	pathRegexes[pattern] = regex
+	return regex, nil
+}
+
+// matchesPathEntry checks path against one entry of a path list. Entries
+// without a match mode keep the original behaviour of matching the path with
+// or without trailing slash, or any path starting with the entry.
+func matchesPathEntry(entry string, path string) bool {
+	entry = strings.TrimSpace(entry)
+	path = strings.TrimSpace(path)
+
+	switch {
+	case strings.HasPrefix(entry, PathMatchExact):
+		return path == strings.TrimPrefix(entry, PathMatchExact)
+	case strings.HasPrefix(entry, PathMatchPrefix):
+		prefix := strings.TrimSuffix(strings.TrimPrefix(entry, PathMatchPrefix), "/")
+		return path == prefix || strings.HasPrefix(path, prefix+"/")
+	case strings.HasPrefix(entry, PathMatchRegex):
+		regex, err := pathRegex(strings.TrimPrefix(entry, PathMatchRegex))
+		return err == nil && regex.MatchString(path)
+	default:
+		return strings.TrimSuffix(entry, "/") == strings.TrimSuffix(path, "/") ||
+			strings.HasPrefix(path, entry)
+	}
+}
+
+// validatePaths rejects path entries whose meaning is ambiguous or invalid
+func validatePaths(paths []string) error {
+	seen := map[string]string{}
+	for _, entry := range paths {
+		entry = strings.TrimSpace(entry)
+		mode, value := "", entry
+		for _, m := range []string{PathMatchExact, PathMatchPrefix, PathMatchRegex} {
+			if strings.HasPrefix(entry, m) {
+				mode, value = m, strings.TrimPrefix(entry, m)
+			}
+		}
+
+		if len(value) == 0 {
+			return errors.New("Empty path '" + entry + "' specified")
+		}
+
+		if mode == PathMatchRegex {
+			if _, err := pathRegex(value); err != nil {
+				return errors.New("Invalid path regex '" + value + "': " + err.Error())
+			}
+		} else {
+			if strings.ContainsAny(value, regexMetaCharacters) {
+				return errors.New("Ambiguous path '" + entry + "' specified, use '" + PathMatchRegex + "' for regular expressions")
+			}
+			if mode != "" && !strings.HasPrefix(value, "/") {
+				return errors.New("Path '" + entry + "' must start with '/'")
+			}
+		}
+
+		if previous, ok := seen[value]; ok && previous != mode {
+			return errors.New("Path '" + value + "' specified with different match modes")
+		}
+		seen[value] = mode
+	}
+	return nil
+}
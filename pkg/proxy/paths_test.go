@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func TestValidatePaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		wantErr bool
+	}{
+		{
+			name:  "TestValidatePathsWithEmptyList",
+			paths: []string{},
+		},
+		{
+			name:  "TestValidatePathsWithAllModes",
+			paths: []string{"/github-webhook/", "exact:/ghprbhook/", "prefix:/project", `regex:^/job/[^/]+/build$`},
+		},
+		{
+			name:    "TestValidatePathsWithRegexWithoutMode",
+			paths:   []string{"/job/.*/build"},
+			wantErr: true,
+		},
+		{
+			name:    "TestValidatePathsWithInvalidRegex",
+			paths:   []string{"regex:^/job/(["},
+			wantErr: true,
+		},
+		{
+			name:    "TestValidatePathsWithEmptyPath",
+			paths:   []string{"exact:"},
+			wantErr: true,
+		},
+		{
+			name:    "TestValidatePathsWithoutLeadingSlash",
+			paths:   []string{"prefix:project"},
+			wantErr: true,
+		},
+		{
+			name:    "TestValidatePathsWithConflictingModes",
+			paths:   []string{"exact:/project", "prefix:/project"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePaths(tt.paths); (err != nil) != tt.wantErr {
+				t.Errorf("validatePaths() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"errors"
+)
+
+const (
+	// PriorityHigh deliveries are taken by the workers before all others
+	PriorityHigh = "high"
+	// PriorityNormal is the priority of deliveries of routes without a priority
+	PriorityNormal = "normal"
+	// PriorityLow deliveries are only taken when no other delivery is waiting
+	PriorityLow = "low"
+)
+
+// deliveryQueue holds the deliveries waiting for a worker, with a queue of
+// queueSize deliveries for each priority
+type deliveryQueue struct {
+	high   chan *Delivery
+	normal chan *Delivery
+	low    chan *Delivery
+}
+
+func newDeliveryQueue(queueSize int) *deliveryQueue {
+	return &deliveryQueue{
+		high:   make(chan *Delivery, queueSize),
+		normal: make(chan *Delivery, queueSize),
+		low:    make(chan *Delivery, queueSize),
+	}
+}
+
+func validatePriority(priority string) error {
+	switch priority {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+		return nil
+	}
+	return errors.New("unknown priority '" + priority + "'")
+}
+
+// queue returns the queue of priority, unknown priorities are normal
+func (q *deliveryQueue) queue(priority string) chan *Delivery {
+	switch priority {
+	case PriorityHigh:
+		return q.high
+	case PriorityLow:
+		return q.low
+	default:
+		return q.normal
+	}
+}
+
+// put queues the delivery, blocking while the queue of its priority is full
+func (q *deliveryQueue) put(delivery *Delivery) {
+	q.queue(delivery.Priority) <- delivery
+}
+
+// offer queues the delivery without blocking, it fails if the queue of its priority is full
+func (q *deliveryQueue) offer(delivery *Delivery) error {
+	select {
+	case q.queue(delivery.Priority) <- delivery:
+		return nil
+	default:
+		return errors.New("Delivery queue is full")
+	}
+}
+
+// take blocks until a delivery is waiting and returns the one with the
+// highest priority
+func (q *deliveryQueue) take() *Delivery {
+	select {
+	case delivery := <-q.high:
+		return delivery
+	default:
+	}
+
+	select {
+	case delivery := <-q.high:
+		return delivery
+	case delivery := <-q.normal:
+		return delivery
+	default:
+	}
+
+	select {
+	case delivery := <-q.high:
+		return delivery
+	case delivery := <-q.normal:
+		return delivery
+	case delivery := <-q.low:
+		return delivery
+	}
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestDeliveryQueue_take(t *testing.T) {
+	q := newDeliveryQueue(5)
+	for _, delivery := range []*Delivery{
+		{ID: "low", Priority: PriorityLow},
+		{ID: "normal-1"},
+		{ID: "high", Priority: PriorityHigh},
+		{ID: "normal-2", Priority: PriorityNormal},
+	} {
+		if err := q.offer(delivery); err != nil {
+			t.Fatalf("deliveryQueue.offer() error = %v", err)
+		}
+	}
+
+	got := []string{}
+	for i := 0; i < 4; i++ {
+		got = append(got, q.take().ID)
+	}
+	if want := []string{"high", "normal-1", "normal-2", "low"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deliveryQueue.take() order = %v, want %v", got, want)
+	}
+}
+
+func TestDeliveryQueue_offerWithFullQueue(t *testing.T) {
+	q := newDeliveryQueue(1)
+	if err := q.offer(&Delivery{ID: "low", Priority: PriorityLow}); err != nil {
+		t.Fatalf("deliveryQueue.offer() error = %v", err)
+	}
+
+	if err := q.offer(&Delivery{ID: "another-low", Priority: PriorityLow}); err == nil {
+		t.Errorf("deliveryQueue.offer() to full low queue error = nil, want an error")
+	}
+	// A flood of low priority deliveries does not hold back high priority ones
+	if err := q.offer(&Delivery{ID: "high", Priority: PriorityHigh}); err != nil {
+		t.Errorf("deliveryQueue.offer() to high queue error = %v", err)
+	}
+}
+
+func TestProxy_proxyRequestWithReleasePriority(t *testing.T) {
+	p, err := NewProxy("http://jenkins.example.com", []string{}, providers.GithubProviderKind, "", []string{},
+		WithRoutes([]Route{{
+			Name:     "releases",
+			Events:   []string{"release"},
+			Upstream: "http://releases.example.com",
+			Priority: PriorityHigh,
+		}}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.deliveries = newDeliveryQueue(1)
+	router := httprouter.New()
+	router.POST("/*path", p.proxyRequest)
+
+	req := httptest.NewRequest(http.MethodPost, "/github-webhook/", strings.NewReader(
+		`{"action":"published","release":{"tag_name":"v1.0.0"},"repository":{"full_name":"stakater/GitWebhookProxy"},"sender":{"login":"octocat"}}`))
+	req.Header.Set(providers.XGitHubEvent, "release")
+	req.Header.Set(providers.XGitHubDelivery, "1")
+	req.Header.Set(providers.ContentTypeHeader, "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v, body %v", rr.Code, http.StatusAccepted, rr.Body.String())
+	}
+	select {
+	case delivery := <-p.deliveries.high:
+		if want := []string{"http://releases.example.com/github-webhook/"}; !reflect.DeepEqual(delivery.RedirectURLs, want) {
+			t.Errorf("release was queued for upstreams %v, want %v", delivery.RedirectURLs, want)
+		}
+	default:
+		t.Errorf("release was not queued with high priority")
+	}
+}
+
+func TestProxy_proxyRequestWithUnroutedGithubEvent(t *testing.T) {
+	tests := []struct {
+		name       string
+		event      string
+		wantStatus int
+	}{
+		{
+			name:       "TestProxyRequestWithRoutedEvent",
+			event:      "release",
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "TestProxyRequestWithUnroutedEvent",
+			event:      "deployment",
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProxy("http://jenkins.example.com", []string{}, providers.GithubProviderKind, "", []string{},
+				WithRoutes([]Route{{Events: []string{"release"}, Upstream: "http://releases.example.com"}}, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.deliveries = newDeliveryQueue(1)
+			router := httprouter.New()
+			router.POST("/*path", p.proxyRequest)
+
+			req := httptest.NewRequest(http.MethodPost, "/github-webhook/", strings.NewReader(
+				`{"repository":{"full_name":"stakater/GitWebhookProxy"},"sender":{"login":"octocat"}}`))
+			req.Header.Set(providers.XGitHubEvent, tt.event)
+			req.Header.Set(providers.XGitHubDelivery, "1")
+			req.Header.Set(providers.ContentTypeHeader, "application/json")
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v, body %v", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
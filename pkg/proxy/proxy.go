@@ -2,26 +2,26 @@ package proxy
 
 import (
 	"bytes"
-	"crypto/tls"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"path"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/stakater/GitWebhookProxy/pkg/parser"
 	"github.com/stakater/GitWebhookProxy/pkg/providers"
-	"github.com/stakater/GitWebhookProxy/pkg/utils"
 )
 
 var (
 	transport = &http.Transport{
-		Proxy:           http.ProxyFromEnvironment,
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		Proxy: http.ProxyFromEnvironment,
 	}
 	httpClient = &http.Client{
 		Timeout:   time.Second * 30,
@@ -36,6 +36,70 @@ type Proxy struct {
 	secret       string
 	ignoredUsers []string
 	allowedUsers []string
+
+	requiredLabels []string
+	ignoredLabels  []string
+
+	forkPolicy              string
+	forkAllowedAssociations []string
+	forkApprovalLabel       string
+
+	commands []Command
+
+	ignoreDrafts         bool
+	readyForReviewAction string
+
+	allowedRepositories []string
+	deniedRepositories  []string
+
+	ignoreBots bool
+
+	routes               []Route
+	allowedUpstreamHosts []string
+
+	workers    int
+	queueSize  int
+	deliveries *deliveryQueue
+	queueDir   string
+	store      *deliveryStore
+
+	retry retryPolicy
+
+	deadLetterDir      string
+	deadLetters        *deliveryStore
+	adminListenAddress string
+
+	circuitFailureThreshold int
+	circuitOpenDuration     time.Duration
+	breakers                map[string]*circuitBreaker
+	breakersMutex           sync.Mutex
+
+	limits        upstreamLimits
+	limiters      map[string]*upstreamLimiter
+	limitersMutex sync.Mutex
+
+	debounceWindow time.Duration
+	pushBatches    map[string]*pushBatch
+	debounceMutex  sync.Mutex
+
+	ordering  string
+	sequencer sequencer
+
+	passedResponseHeaders []string
+	deniedResponseHeaders []string
+	hideUpstreamBody      bool
+
+	maxPayloadSize int64
+
+	upstreamTLS     []UpstreamTLS
+	upstreamClients []upstreamClient
+
+	upstreamSecrets []UpstreamSecret
+
+	upstreamAuth           []UpstreamAuth
+	upstreamAuthenticators []*upstreamAuthenticator
+
+	headerPolicy HeaderPolicy
 }
 
 func (p *Proxy) isPathAllowed(path string) bool {
@@ -44,12 +108,13 @@ func (p *Proxy) isPathAllowed(path string) bool {
 		return true
 	}
 
-	// Check if given passed exists in allowedPaths
-	for _, p := range p.allowedPaths {
-		allowedPath := strings.TrimSpace(p)
-		incomingPath := strings.TrimSpace(path)
-		if strings.TrimSuffix(allowedPath, "/") ==
-			strings.TrimSuffix(incomingPath, "/") || strings.HasPrefix(incomingPath, allowedPath) {
+	return matchesPath(p.allowedPaths, path)
+}
+
+// matchesPath checks if given path exists in paths
+func matchesPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if matchesPathEntry(p, path) {
 			return true
 		}
 	}
@@ -58,7 +123,7 @@ func (p *Proxy) isPathAllowed(path string) bool {
 
 func (p *Proxy) isIgnoredUser(committer string) bool {
 	if len(p.ignoredUsers) > 0 {
-		if exists, _ := utils.InArray(p.ignoredUsers, committer); exists {
+		if matchesUser(p.ignoredUsers, committer) {
 			return true
 		}
 	}
@@ -72,7 +137,7 @@ func (p *Proxy) isIgnoredUser(committer string) bool {
 
 func (p *Proxy) isAllowedUser(committer string) bool {
 	if len(p.allowedUsers) > 0 {
-		if exists, _ := utils.InArray(p.allowedUsers, committer); exists {
+		if matchesUser(p.allowedUsers, committer) {
 			return true
 		}
 
@@ -82,7 +147,8 @@ func (p *Proxy) isAllowedUser(committer string) bool {
 	return true
 }
 
-func (p *Proxy) redirect(hook *providers.Hook, redirectURL string) (*http.Response, error) {
+// redirectOnce sends the hook to redirectURL in a single attempt
+func (p *Proxy) redirectOnce(hook *providers.Hook, redirectURL string) (*http.Response, error) {
 	if hook == nil {
 		return nil, errors.New("Cannot redirect with nil Hook")
 	}
@@ -98,30 +164,42 @@ func (p *Proxy) redirect(hook *providers.Hook, redirectURL string) (*http.Respon
 		url.Scheme = "http"
 	}
 
-	// Create Redirect request
-	req, err := http.NewRequest(hook.RequestMethod, url.String(), bytes.NewBuffer(hook.Payload))
+	// Create Redirect request, with a new reader of the payload for every attempt
+	req, err := http.NewRequest(hook.RequestMethod, url.String(), bytes.NewReader(hook.Payload))
+
+	if err != nil {
+		return nil, err
+	}
 
+	headers, err := p.headersFor(hook, redirectURL)
 	if err != nil {
 		return nil, err
 	}
 
 	// Set Headers from hook
-	for key, value := range hook.Headers {
+	for key, value := range headers {
 		req.Header.Add(key, value)
 	}
 
-	return httpClient.Do(req)
+	authenticator := p.authenticatorFor(redirectURL)
+	if authenticator != nil {
+		if err := p.authenticate(authenticator, req); err != nil {
+			return nil, errors.New("Cannot authenticate to upstream: " + err.Error())
+		}
+	}
 
+	resp, err := p.clientFor(redirectURL).Do(req)
+	if err != nil && authenticator != nil {
+		return nil, authenticator.redact(err)
+	}
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && authenticator != nil && authenticator.Type == AuthOAuth2 {
+		authenticator.invalidate(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+	}
+	return resp, err
 }
 
 func (p *Proxy) proxyRequest(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	redirectURL := p.upstreamURL + r.URL.Path
-
-	if r.URL.RawQuery != "" {
-		redirectURL += "?" + r.URL.RawQuery
-	}
-
-	log.Printf("Proxying Request from '%s', to upstream '%s'\n", r.URL, redirectURL)
+	log.Printf("Proxying Request from '%s'\n", r.URL)
 
 	if !p.isPathAllowed(r.URL.Path) {
 		log.Printf("Not allowed to proxy path: '%s'", r.URL.Path)
@@ -136,13 +214,25 @@ func (p *Proxy) proxyRequest(w http.ResponseWriter, r *http.Request, params http
 		return
 	}
 
-	hook, err := parser.Parse(r, provider)
+	hook, err := parser.ParseWithLimit(r, provider, p.maxPayloadSize)
+	if err == parser.ErrPayloadTooLarge {
+		log.Printf("Error Parsing Hook: payload exceeds %d bytes", p.maxPayloadSize)
+		http.Error(w, "Error parsing Hook: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		log.Printf("Error Parsing Hook: %s", err)
 		http.Error(w, "Error parsing Hook: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if event := provider.GetEvent(*hook); !p.isProxiedEvent(event) {
+		log.Printf("Ignoring request for event without route: %s", event)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("Ignoring request for event: %s", event)))
+		return
+	}
+
 	committer := provider.GetCommitter(*hook)
 	log.Printf("Incoming request from user: %s", committer)
 	if p.isIgnoredUser(committer) || (!p.isAllowedUser(committer)) {
@@ -152,21 +242,156 @@ func (p *Proxy) proxyRequest(w http.ResponseWriter, r *http.Request, params http
 		return
 	}
 
+	if p.ignoreBots && provider.IsBotSender(*hook) {
+		log.Printf("Ignoring request from bot: %s", committer)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("Ignoring request from bot: %s", committer)))
+		return
+	}
+
 	if len(strings.TrimSpace(p.secret)) > 0 && !provider.Validate(*hook) {
 		log.Printf("Error Validating Hook: %v", err)
 		http.Error(w, "Error validating Hook", http.StatusBadRequest)
 		return
 	}
 
+	p.forwardHeaders(hook.Headers, r)
+
+	repository := provider.GetRepository(*hook)
+	if !p.isAllowedRepository(repository) {
+		log.Printf("Not allowed to proxy repository: '%s'", repository)
+		http.Error(w, "Not allowed to proxy repository: '"+repository+"'", http.StatusForbidden)
+		return
+	}
+
+	info := HookInfo{
+		Provider:   provider.GetProviderName(),
+		Path:       r.URL.Path,
+		Event:      string(provider.GetEvent(*hook)),
+		Repository: repository,
+		Ref:        provider.GetRef(*hook),
+	}
+	route := p.routeFor(info)
+	redirectPath := route.rewritePath(r.URL.Path)
+
+	pullRequest := provider.GetPullRequest(*hook)
+	if !p.hasAllowedLabels(pullRequest) {
+		log.Printf("Ignoring request with labels: %v", pullRequest.Labels)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("Ignoring request with labels: %v", pullRequest.Labels)))
+		return
+	}
+
+	if !p.isTrustedFork(pullRequest) {
+		if p.forkPolicy == ForkPolicyHold {
+			log.Printf("Holding pull request from fork with author association '%s'", pullRequest.AuthorAssociation)
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("Holding pull request from fork until it is approved"))
+			return
+		}
+		log.Printf("Blocking pull request from fork with author association '%s'", pullRequest.AuthorAssociation)
+		http.Error(w, "Not allowed to proxy pull request from fork", http.StatusForbidden)
+		return
+	}
+
+	if p.isIgnoredDraft(pullRequest) {
+		log.Printf("Ignoring request for draft pull request")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Ignoring request for draft pull request"))
+		return
+	}
+
+	if pullRequest != nil && pullRequest.Action == providers.PullRequestReadyForReviewAction &&
+		len(p.readyForReviewAction) > 0 {
+		if err := provider.SetPullRequestAction(hook, p.readyForReviewAction); err != nil {
+			log.Printf("Error replacing ready for review action: %s", err)
+			http.Error(w, "Error replacing ready for review action", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Replaced ready for review action with '%s'", p.readyForReviewAction)
+	}
+
+	comment := provider.GetComment(*hook)
+	if command, args := p.findCommand(comment); command != nil {
+		if !command.isTrusted(comment) {
+			log.Printf("Ignoring command '%s' from untrusted user: %s", command.Name, comment.Author)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(fmt.Sprintf("Ignoring command '%s' from untrusted user: %s", command.Name, comment.Author)))
+			return
+		}
+
+		redirectPath = command.Path
+		setCommandHeaders(hook, command, args)
+		log.Printf("Proxying command '%s' with arguments %v to path '%s'\n", command.Name, args, redirectPath)
+	}
+
+	if push := provider.GetPush(*hook); push != nil && p.debounceWindow > 0 {
+		before, latest := p.debounce(repository+" "+info.Ref, push.Before)
+		if !latest {
+			log.Printf("Push '%s' to '%s' in '%s' superseded by a newer push", push.After, info.Ref, repository)
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(fmt.Sprintf("Push '%s' superseded by a newer push to '%s'", push.After, info.Ref)))
+			return
+		}
+		if before != push.Before {
+			if err := provider.SetPushBefore(hook, before); err != nil {
+				log.Printf("Error replacing before SHA of push: %s", err)
+				http.Error(w, "Error replacing before SHA of push", http.StatusBadRequest)
+				return
+			}
+			log.Printf("Coalesced pushes to '%s' in '%s' from '%s' to '%s'", info.Ref, repository, before, push.After)
+		}
+	}
+
+	info.Path = redirectPath
+	redirectURLs, err := route.upstreamURLs(info, p.allowedUpstreamHosts)
+	if err != nil {
+		log.Printf("Error building upstream URL for route '%s': %s", route.Name, err)
+		http.Error(w, "Error building upstream URL: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	for i := range redirectURLs {
+		redirectURLs[i] = appendQuery(redirectURLs[i], r.URL.RawQuery)
+	}
+
+	orderingKey := p.orderingKey(info)
+	if p.deliveries != nil {
+		p.proxyAsync(w, r, hook, redirectURLs, route.Policy, orderingKey, route.Priority)
+		return
+	}
+
+	if len(orderingKey) > 0 {
+		defer p.sequencer.wait(orderingKey, p.sequencer.reserve(orderingKey))()
+	}
+
+	if len(redirectURLs) > 1 {
+		log.Printf("Proxying Request from '%s', to upstreams %v\n", r.URL, redirectURLs)
+		p.proxyFanOut(w, r, hook, redirectURLs, route.Policy)
+		return
+	}
+
+	redirectURL := redirectURLs[0]
+	log.Printf("Proxying Request from '%s', to upstream '%s'\n", r.URL, redirectURL)
 	resp, errs := p.redirect(hook, redirectURL)
 	if errs != nil {
 		log.Printf("Error Redirecting '%s' to upstream '%s': %s\n", r.URL, redirectURL, errs)
+		// The Git provider retries hooks rejected by the limits or the circuit
+		// breaker, so they aren't dead-lettered
+		if statusCode := limitStatusCode(errs); statusCode != 0 {
+			http.Error(w, "Error Redirecting '"+r.URL.String()+"' to upstream '"+upstreamKey(redirectURL)+"': "+errs.Error(), statusCode)
+			return
+		}
+		p.deadLetterHook(r, hook, route.Policy, []targetResult{{URL: redirectURL, Err: errs}})
 		http.Error(w, "Error Redirecting '"+r.URL.String()+"' to upstream '"+redirectURL+"'", http.StatusInternalServerError)
 		return
 	}
 
+	defer resp.Body.Close()
+	p.copyResponseHeaders(w, resp)
+
 	if resp.StatusCode >= 400 {
 		log.Printf("Error Redirecting '%s' to upstream '%s', Upstream Redirect Status: %s\n", r.URL, redirectURL, resp.Status)
+		p.deadLetterHook(r, hook, route.Policy, []targetResult{{URL: redirectURL, StatusCode: resp.StatusCode, Status: resp.Status}})
 		http.Error(w, "Error Redirecting '"+r.URL.String()+"' to upstream '"+redirectURL+"' Upstream Redirect Status:"+resp.Status, resp.StatusCode)
 		return
 	}
@@ -174,15 +399,17 @@ func (p *Proxy) proxyRequest(w http.ResponseWriter, r *http.Request, params http
 	log.Printf("Redirected incomming request '%s' to '%s' with Response: '%s'\n",
 		r.URL, redirectURL, resp.Status)
 
-	responseBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error Reading upstream '%s' response body\n", r.URL)
-		http.Error(w, "Error Reading upstream '"+redirectURL+"' Response body", http.StatusInternalServerError)
+	if p.hideUpstreamBody {
+		w.WriteHeader(resp.StatusCode)
 		return
 	}
 
+	// Stream the upstream response body instead of buffering it, the status is
+	// sent already so a failure can only be logged
 	w.WriteHeader(resp.StatusCode)
-	w.Write(responseBody)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("Error Reading upstream '%s' response body: %s\n", redirectURL, err)
+	}
 }
 
 // Health Check Endpoint
@@ -201,12 +428,19 @@ func (p *Proxy) Run(listenAddress string) error {
 	router.GET("/health", p.health)
 	router.POST("/*path", p.proxyRequest)
 
+	if len(p.adminListenAddress) > 0 {
+		go func() {
+			log.Printf("Admin endpoints listening at: %s", p.adminListenAddress)
+			log.Fatal(http.ListenAndServe(p.adminListenAddress, p.adminRouter()))
+		}()
+	}
+
 	log.Printf("Listening at: %s", listenAddress)
 	return http.ListenAndServe(listenAddress, router)
 }
 
 func NewProxy(upstreamURL string, allowedPaths []string,
-	provider string, secret string, ignoredUsers []string) (*Proxy, error) {
+	provider string, secret string, ignoredUsers []string, options ...Option) (*Proxy, error) {
 	// Validate Params
 	if len(strings.TrimSpace(upstreamURL)) == 0 {
 		return nil, errors.New("Cannot create Proxy with empty upstreamURL")
@@ -217,12 +451,176 @@ func NewProxy(upstreamURL string, allowedPaths []string,
 	if allowedPaths == nil {
 		return nil, errors.New("Cannot create Proxy with nil allowedPaths")
 	}
+	if err := validatePaths(allowedPaths); err != nil {
+		return nil, errors.New("Cannot create Proxy with invalid allowedPaths: " + err.Error())
+	}
 
-	return &Proxy{
+	p := &Proxy{
 		provider:     provider,
 		upstreamURL:  upstreamURL,
 		allowedPaths: allowedPaths,
 		secret:       secret,
 		ignoredUsers: ignoredUsers,
-	}, nil
+	}
+	for _, option := range options {
+		option(p)
+	}
+
+	for i := range p.routes {
+		if err := p.routes[i].compile(); err != nil {
+			return nil, err
+		}
+		if p.routes[i].isTemplated() && len(p.allowedUpstreamHosts) == 0 {
+			return nil, errors.New("Route '" + p.routes[i].Name + "' has a templated upstream but no allowed upstream hosts are configured")
+		}
+	}
+
+	for i := range p.commands {
+		if err := p.commands[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, users := range [][]string{p.ignoredUsers, p.allowedUsers} {
+		for _, user := range users {
+			if pattern, ok := userPattern(user); ok {
+				if _, err := regexp.Compile(pattern); err != nil {
+					return nil, errors.New("Cannot create Proxy with invalid user pattern '" + user + "'")
+				}
+			}
+		}
+	}
+
+	for _, patterns := range [][]string{p.allowedRepositories, p.deniedRepositories} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+				return nil, errors.New("Cannot create Proxy with invalid repository pattern '" + pattern + "'")
+			}
+		}
+	}
+
+	if p.retry.maxRetries < 0 {
+		return nil, errors.New("Cannot create Proxy with negative retries")
+	}
+	if p.retry.initialInterval < 0 || p.retry.maxInterval < 0 || p.retry.maxElapsedTime < 0 {
+		return nil, errors.New("Cannot create Proxy with negative retry intervals or elapsed time")
+	}
+
+	if p.circuitFailureThreshold < 0 || p.circuitOpenDuration < 0 {
+		return nil, errors.New("Cannot create Proxy with negative circuit breaker threshold or open duration")
+	}
+
+	if p.limits.maxConcurrent < 0 || p.limits.requestsPerSecond < 0 || p.limits.maxWait < 0 {
+		return nil, errors.New("Cannot create Proxy with negative upstream limits")
+	}
+	if p.limits.requestsPerSecond > 0 && p.limits.burst < 1 {
+		return nil, errors.New("Cannot create Proxy with upstream rate limit burst below 1")
+	}
+	switch p.limits.policy {
+	case "", LimitPolicyQueue, LimitPolicyReject:
+	default:
+		return nil, errors.New("Cannot create Proxy with unknown upstream limit policy '" + p.limits.policy + "'")
+	}
+
+	for _, upstreamTLS := range p.upstreamTLS {
+		for _, pattern := range upstreamTLS.Hosts {
+			if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+				return nil, errors.New("Cannot create Proxy with invalid upstream TLS host pattern '" + pattern + "'")
+			}
+		}
+		tlsConfig, err := upstreamTLS.tlsConfig()
+		if err != nil {
+			return nil, errors.New("Cannot create Proxy with invalid upstream TLS: " + err.Error())
+		}
+		p.upstreamClients = append(p.upstreamClients, upstreamClient{
+			hosts:  upstreamTLS.Hosts,
+			client: newHTTPClient(tlsConfig),
+		})
+	}
+
+	for _, upstreamSecret := range p.upstreamSecrets {
+		if len(upstreamSecret.Secret) == 0 {
+			return nil, errors.New("Cannot create Proxy with empty upstream secret")
+		}
+		for _, pattern := range upstreamSecret.Hosts {
+			if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+				return nil, errors.New("Cannot create Proxy with invalid upstream secret host pattern '" + pattern + "'")
+			}
+		}
+	}
+
+	if err := p.headerPolicy.validate(); err != nil {
+		return nil, errors.New("Cannot create Proxy with invalid header policy: " + err.Error())
+	}
+
+	for _, upstreamAuth := range p.upstreamAuth {
+		if err := upstreamAuth.validate(); err != nil {
+			return nil, errors.New("Cannot create Proxy with invalid upstream auth: " + err.Error())
+		}
+		p.upstreamAuthenticators = append(p.upstreamAuthenticators, &upstreamAuthenticator{UpstreamAuth: upstreamAuth})
+	}
+
+	if p.maxPayloadSize < 0 {
+		return nil, errors.New("Cannot create Proxy with negative maximum payload size")
+	}
+
+	if p.debounceWindow < 0 {
+		return nil, errors.New("Cannot create Proxy with negative debounce window")
+	}
+
+	switch p.ordering {
+	case "", OrderingRepository, OrderingRef:
+	default:
+		return nil, errors.New("Cannot create Proxy with unknown ordering '" + p.ordering + "'")
+	}
+
+	if p.workers < 0 || p.queueSize < 0 {
+		return nil, errors.New("Cannot create Proxy with negative workers or queue size")
+	}
+	if len(p.queueDir) > 0 && p.workers == 0 {
+		return nil, errors.New("Cannot create Proxy with queue directory without async delivery")
+	}
+	// The static headers are set again from the header policy when a stored
+	// delivery is delivered
+	var staticHeaders []string
+	for name := range p.headerPolicy.Add {
+		staticHeaders = append(staticHeaders, name)
+	}
+	if len(p.queueDir) > 0 {
+		store, err := newDeliveryStore(p.queueDir, staticHeaders)
+		if err != nil {
+			return nil, err
+		}
+		p.store = store
+	}
+	if len(p.deadLetterDir) > 0 {
+		deadLetters, err := newDeliveryStore(p.deadLetterDir, staticHeaders)
+		if err != nil {
+			return nil, err
+		}
+		p.deadLetters = deadLetters
+	}
+
+	switch p.forkPolicy {
+	case "", ForkPolicyAllow, ForkPolicyBlock, ForkPolicyHold:
+	default:
+		return nil, errors.New("Cannot create Proxy with unknown fork policy '" + p.forkPolicy + "'")
+	}
+
+	// Nothing is delivered before the Proxy is valid
+	var pending []*Delivery
+	if p.store != nil {
+		var err error
+		if pending, err = p.store.list(); err != nil {
+			return nil, err
+		}
+	}
+	if p.workers > 0 {
+		p.startWorkers(p.workers, p.queueSize)
+	}
+	if p.store != nil {
+		p.resumePending(pending)
+	}
+
+	return p, nil
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	httpmock "github.com/jarcoal/httpmock"
@@ -216,6 +217,110 @@ func TestProxy_isPathAllowed(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "isPathAllowedWithRawPrefixAllowingLongerSegment",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"/project"},
+				secret:       "secret",
+			},
+			args: args{
+				path: "/projectx-secret-endpoint",
+			},
+			want: true,
+		},
+		{
+			name: "isPathAllowedWithSegmentPrefixAndLongerSegment",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"prefix:/project"},
+				secret:       "secret",
+			},
+			args: args{
+				path: "/projectx-secret-endpoint",
+			},
+			want: false,
+		},
+		{
+			name: "isPathAllowedWithSegmentPrefixAndSubPath",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"prefix:/project/"},
+				secret:       "secret",
+			},
+			args: args{
+				path: "/project/app/build",
+			},
+			want: true,
+		},
+		{
+			name: "isPathAllowedWithSegmentPrefixAndSamePath",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"prefix:/project"},
+				secret:       "secret",
+			},
+			args: args{
+				path: "/project",
+			},
+			want: true,
+		},
+		{
+			name: "isPathAllowedWithExactPath",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"exact:/github-webhook/"},
+				secret:       "secret",
+			},
+			args: args{
+				path: "/github-webhook/",
+			},
+			want: true,
+		},
+		{
+			name: "isPathAllowedWithExactPathAndSubPath",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"exact:/github-webhook/"},
+				secret:       "secret",
+			},
+			args: args{
+				path: "/github-webhook/other",
+			},
+			want: false,
+		},
+		{
+			name: "isPathAllowedWithRegexPath",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"regex:^/job/[^/]+/build$"},
+				secret:       "secret",
+			},
+			args: args{
+				path: "/job/app/build",
+			},
+			want: true,
+		},
+		{
+			name: "isPathAllowedWithRegexPathNotMatching",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"regex:^/job/[^/]+/build$"},
+				secret:       "secret",
+			},
+			args: args{
+				path: "/job/app/configSubmit",
+			},
+			want: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -991,6 +1096,34 @@ func TestProxy_isIgnoredUser(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "TestIsIgnoredUserWithRegex",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"/path1", "/path2"},
+				secret:       "secret",
+				ignoredUsers: []string{"user1", `/\[bot\]$/`},
+			},
+			args: args{
+				committer: "dependabot[bot]",
+			},
+			want: true,
+		},
+		{
+			name: "TestIsIgnoredUserWithRegexNotMatching",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"/path1", "/path2"},
+				secret:       "secret",
+				ignoredUsers: []string{`/^renovate/`},
+			},
+			args: args{
+				committer: "user1",
+			},
+			want: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1067,6 +1200,20 @@ func TestProxy_isAllowedUser(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "TestIsAllowedUserWithRegex",
+			fields: fields{
+				provider:     providers.GithubProviderKind,
+				upstreamURL:  "https://dummyurl.com",
+				allowedPaths: []string{"/path1", "/path2"},
+				secret:       "secret",
+				allowedUsers: []string{`/^stakater-/`},
+			},
+			args: args{
+				committer: "stakater-user",
+			},
+			want: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1083,3 +1230,376 @@ func TestProxy_isAllowedUser(t *testing.T) {
 		})
 	}
 }
+
+func TestProxy_hasAllowedLabels(t *testing.T) {
+	type fields struct {
+		requiredLabels []string
+		ignoredLabels  []string
+	}
+	type args struct {
+		pullRequest *providers.PullRequest
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   bool
+	}{
+		{
+			name: "TestHasAllowedLabelsWithNoPullRequest",
+			fields: fields{
+				requiredLabels: []string{"ok-to-test"},
+			},
+			args: args{
+				pullRequest: nil,
+			},
+			want: true,
+		},
+		{
+			name: "TestHasAllowedLabelsWithNoLabelsConfigured",
+			args: args{
+				pullRequest: &providers.PullRequest{Labels: []string{"bug"}},
+			},
+			want: true,
+		},
+		{
+			name: "TestHasAllowedLabelsWithRequiredLabel",
+			fields: fields{
+				requiredLabels: []string{"ok-to-test"},
+			},
+			args: args{
+				pullRequest: &providers.PullRequest{Labels: []string{"bug", "ok-to-test"}},
+			},
+			want: true,
+		},
+		{
+			name: "TestHasAllowedLabelsWithMissingRequiredLabel",
+			fields: fields{
+				requiredLabels: []string{"ok-to-test"},
+			},
+			args: args{
+				pullRequest: &providers.PullRequest{Labels: []string{"bug"}},
+			},
+			want: false,
+		},
+		{
+			name: "TestHasAllowedLabelsWithIgnoredLabel",
+			fields: fields{
+				requiredLabels: []string{"ok-to-test"},
+				ignoredLabels:  []string{"do-not-build"},
+			},
+			args: args{
+				pullRequest: &providers.PullRequest{Labels: []string{"ok-to-test", "do-not-build"}},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{
+				requiredLabels: tt.fields.requiredLabels,
+				ignoredLabels:  tt.fields.ignoredLabels,
+			}
+			if got := p.hasAllowedLabels(tt.args.pullRequest); got != tt.want {
+				t.Errorf("Proxy.hasAllowedLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_isTrustedFork(t *testing.T) {
+	type fields struct {
+		forkPolicy              string
+		forkAllowedAssociations []string
+		forkApprovalLabel       string
+	}
+	type args struct {
+		pullRequest *providers.PullRequest
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   bool
+	}{
+		{
+			name: "TestIsTrustedForkWithNoPullRequest",
+			fields: fields{
+				forkPolicy: ForkPolicyBlock,
+			},
+			want: true,
+		},
+		{
+			name: "TestIsTrustedForkWithPullRequestFromSameRepository",
+			fields: fields{
+				forkPolicy: ForkPolicyBlock,
+			},
+			args: args{
+				pullRequest: &providers.PullRequest{Fork: false, AuthorAssociation: "NONE"},
+			},
+			want: true,
+		},
+		{
+			name: "TestIsTrustedForkWithAllowPolicy",
+			fields: fields{
+				forkPolicy: ForkPolicyAllow,
+			},
+			args: args{
+				pullRequest: &providers.PullRequest{Fork: true, AuthorAssociation: "NONE"},
+			},
+			want: true,
+		},
+		{
+			name: "TestIsTrustedForkWithUntrustedAuthor",
+			fields: fields{
+				forkPolicy:              ForkPolicyBlock,
+				forkAllowedAssociations: []string{"OWNER", "MEMBER", "COLLABORATOR"},
+			},
+			args: args{
+				pullRequest: &providers.PullRequest{Fork: true, AuthorAssociation: "CONTRIBUTOR"},
+			},
+			want: false,
+		},
+		{
+			name: "TestIsTrustedForkWithTrustedAuthor",
+			fields: fields{
+				forkPolicy:              ForkPolicyHold,
+				forkAllowedAssociations: []string{"OWNER", "MEMBER", "COLLABORATOR"},
+			},
+			args: args{
+				pullRequest: &providers.PullRequest{Fork: true, AuthorAssociation: "MEMBER"},
+			},
+			want: true,
+		},
+		{
+			name: "TestIsTrustedForkWithApprovalLabel",
+			fields: fields{
+				forkPolicy:        ForkPolicyHold,
+				forkApprovalLabel: "ok-to-test",
+			},
+			args: args{
+				pullRequest: &providers.PullRequest{Fork: true, Labels: []string{"ok-to-test"}},
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{
+				forkPolicy:              tt.fields.forkPolicy,
+				forkAllowedAssociations: tt.fields.forkAllowedAssociations,
+				forkApprovalLabel:       tt.fields.forkApprovalLabel,
+			}
+			if got := p.isTrustedFork(tt.args.pullRequest); got != tt.want {
+				t.Errorf("Proxy.isTrustedFork() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_isAllowedRepository(t *testing.T) {
+	type fields struct {
+		allowedRepositories []string
+		deniedRepositories  []string
+	}
+	type args struct {
+		repository string
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   bool
+	}{
+		{
+			name: "TestIsAllowedRepositoryWithEmptyLists",
+			args: args{
+				repository: "stakater/GitWebhookProxy",
+			},
+			want: true,
+		},
+		{
+			name: "TestIsAllowedRepositoryWithExactName",
+			fields: fields{
+				allowedRepositories: []string{"stakater/GitWebhookProxy"},
+			},
+			args: args{
+				repository: "stakater/gitwebhookproxy",
+			},
+			want: true,
+		},
+		{
+			name: "TestIsAllowedRepositoryWithGlob",
+			fields: fields{
+				allowedRepositories: []string{"platform/*"},
+			},
+			args: args{
+				repository: "platform/jenkins",
+			},
+			want: true,
+		},
+		{
+			name: "TestIsAllowedRepositoryWithGlobNotMatchingSubgroup",
+			fields: fields{
+				allowedRepositories: []string{"platform/*"},
+			},
+			args: args{
+				repository: "platform/tools/jenkins",
+			},
+			want: false,
+		},
+		{
+			name: "TestIsAllowedRepositoryWithUnknownRepository",
+			fields: fields{
+				allowedRepositories: []string{"platform/*"},
+			},
+			args: args{
+				repository: "someone/else",
+			},
+			want: false,
+		},
+		{
+			name: "TestIsAllowedRepositoryWithEmptyRepository",
+			fields: fields{
+				allowedRepositories: []string{"platform/*"},
+			},
+			args: args{
+				repository: "",
+			},
+			want: false,
+		},
+		{
+			name: "TestIsAllowedRepositoryWithDeniedRepository",
+			fields: fields{
+				allowedRepositories: []string{"platform/*"},
+				deniedRepositories:  []string{"platform/sandbox-*"},
+			},
+			args: args{
+				repository: "platform/sandbox-1",
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{
+				allowedRepositories: tt.fields.allowedRepositories,
+				deniedRepositories:  tt.fields.deniedRepositories,
+			}
+			if got := p.isAllowedRepository(tt.args.repository); got != tt.want {
+				t.Errorf("Proxy.isAllowedRepository() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_proxyRequestWithMaxPayloadSize(t *testing.T) {
+	p, err := NewProxy("http://localhost", []string{}, providers.GitlabProviderKind, proxyGitlabTestSecret, []string{},
+		WithMaxPayloadSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := httprouter.New()
+	router.POST("/*path", p.proxyRequest)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, createGitlabRequest(http.MethodPost, "/post",
+		proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody))
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestProxy_proxyRequestWithDraftPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		provider       string
+		event          string
+		payload        string
+		wantStatusCode int
+		wantAction     string
+	}{
+		{
+			name:           "TestProxyRequestWithGithubDraft",
+			provider:       providers.GithubProviderKind,
+			event:          string(providers.GithubPullRequestEvent),
+			payload:        `{"action":"opened","pull_request":{"draft":true},"sender":{"login":"octocat"}}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "TestProxyRequestWithGithubReadyForReview",
+			provider:       providers.GithubProviderKind,
+			event:          string(providers.GithubPullRequestEvent),
+			payload:        `{"action":"ready_for_review","pull_request":{"draft":false},"sender":{"login":"octocat"}}`,
+			wantStatusCode: http.StatusOK,
+			wantAction:     "opened",
+		},
+		{
+			name:           "TestProxyRequestWithGitlabDraft",
+			provider:       providers.GitlabProviderKind,
+			event:          string(providers.GitlabMergeRequestEvent),
+			payload:        `{"object_kind":"merge_request","object_attributes":{"action":"open","draft":true}}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:     "TestProxyRequestWithGitlabMarkedAsReady",
+			provider: providers.GitlabProviderKind,
+			event:    string(providers.GitlabMergeRequestEvent),
+			payload: `{"object_kind":"merge_request","object_attributes":{"action":"update"},
+				"changes":{"draft":{"previous":true,"current":false}}}`,
+			wantStatusCode: http.StatusOK,
+			wantAction:     "opened",
+		},
+		{
+			name:     "TestProxyRequestWithGitlabMarkedAsReadyWithWorkInProgress",
+			provider: providers.GitlabProviderKind,
+			event:    string(providers.GitlabMergeRequestEvent),
+			payload: `{"object_kind":"merge_request","object_attributes":{"action":"update"},
+				"changes":{"work_in_progress":{"previous":true,"current":false}}}`,
+			wantStatusCode: http.StatusOK,
+			wantAction:     "opened",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamPayload []byte
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamPayload, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer upstream.Close()
+
+			p, err := NewProxy(upstream.URL, []string{}, tt.provider, "", []string{}, WithDraftPolicy(true, "opened"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router := httprouter.New()
+			router.POST("/*path", p.proxyRequest)
+
+			req := httptest.NewRequest(http.MethodPost, "/post", strings.NewReader(tt.payload))
+			req.Header.Set(providers.ContentTypeHeader, "application/json")
+			if tt.provider == providers.GithubProviderKind {
+				req.Header.Set(providers.XGitHubEvent, tt.event)
+				req.Header.Set(providers.XGitHubDelivery, "1")
+			} else {
+				req.Header.Set(providers.XGitlabEvent, tt.event)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatusCode {
+				t.Fatalf("handler returned wrong status code: got %v want %v, body %v", rr.Code, tt.wantStatusCode, rr.Body.String())
+			}
+			if len(tt.wantAction) == 0 {
+				if upstreamPayload != nil {
+					t.Errorf("draft was proxied to the upstream: %s", upstreamPayload)
+				}
+				return
+			}
+			if !strings.Contains(string(upstreamPayload), `"action":"`+tt.wantAction+`"`) {
+				t.Errorf("upstream received %s, want action %v", upstreamPayload, tt.wantAction)
+			}
+		})
+	}
+}
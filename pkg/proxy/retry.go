@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+// retryPolicy configures how often and how long failed upstream requests are retried
+type retryPolicy struct {
+	maxRetries      int
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	maxElapsedTime  time.Duration
+}
+
+// isRetryableStatus checks whether the upstream may accept the request when retried
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// maxBackoff bounds the doubling of intervals without a maximum interval
+const maxBackoff = time.Duration(math.MaxInt64 / 4)
+
+// backoff returns the exponential interval before the given retry with +/-50%
+// jitter. A maxInterval of 0 doesn't cap the interval.
+func (r retryPolicy) backoff(retry int) time.Duration {
+	interval := r.initialInterval
+	for i := 0; i < retry && interval < maxBackoff && (r.maxInterval == 0 || interval < r.maxInterval); i++ {
+		interval *= 2
+	}
+	if r.maxInterval > 0 && interval > r.maxInterval {
+		interval = r.maxInterval
+	}
+	return interval/2 + time.Duration(rand.Int63n(int64(interval)+1))
+}
+
+// retryAfter parses the Retry-After header given in seconds or as HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// redirect sends the hook to redirectURL, retrying network errors and
+// retryable status codes with exponential backoff. The response of the last
+// attempt is returned. No further attempt is made once the circuit of the
+// upstream opened or its limits rejected the request.
+func (p *Proxy) redirect(hook *providers.Hook, redirectURL string) (*http.Response, error) {
+	start := time.Now()
+	for retry := 0; ; retry++ {
+		resp, err := p.redirectThroughBreaker(hook, redirectURL)
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if hook == nil || limitStatusCode(err) != 0 || retry >= p.retry.maxRetries {
+			return resp, err
+		}
+
+		wait := p.retry.backoff(retry)
+		if err == nil {
+			if after, ok := retryAfter(resp); ok {
+				wait = after
+			}
+		}
+		if p.retry.maxElapsedTime > 0 && time.Since(start)+wait > p.retry.maxElapsedTime {
+			return resp, err
+		}
+
+		if err != nil {
+			log.Printf("Error Redirecting to upstream '%s': %s, retrying in %s\n", redirectURL, err, wait)
+		} else {
+			log.Printf("Upstream '%s' returned '%s', retrying in %s\n", redirectURL, resp.Status, wait)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestProxy_redirectWithRetries(t *testing.T) {
+	tests := []struct {
+		name           string
+		retry          retryPolicy
+		failures       int32
+		failureStatus  int
+		wantStatusCode int
+		wantAttempts   int32
+	}{
+		{
+			name:           "TestRedirectWithoutRetries",
+			retry:          retryPolicy{},
+			failures:       1,
+			failureStatus:  http.StatusServiceUnavailable,
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantAttempts:   1,
+		},
+		{
+			name:           "TestRedirectWithRetriesSucceeding",
+			retry:          retryPolicy{maxRetries: 3, initialInterval: time.Millisecond, maxInterval: 4 * time.Millisecond},
+			failures:       2,
+			failureStatus:  http.StatusBadGateway,
+			wantStatusCode: http.StatusOK,
+			wantAttempts:   3,
+		},
+		{
+			name:           "TestRedirectWithRetriesExhausted",
+			retry:          retryPolicy{maxRetries: 2, initialInterval: time.Millisecond, maxInterval: 4 * time.Millisecond},
+			failures:       5,
+			failureStatus:  http.StatusTooManyRequests,
+			wantStatusCode: http.StatusTooManyRequests,
+			wantAttempts:   3,
+		},
+		{
+			name:           "TestRedirectWithNonRetryableStatus",
+			retry:          retryPolicy{maxRetries: 3, initialInterval: time.Millisecond},
+			failures:       1,
+			failureStatus:  http.StatusBadRequest,
+			wantStatusCode: http.StatusBadRequest,
+			wantAttempts:   1,
+		},
+		{
+			name:           "TestRedirectWithMaxElapsedTimeExceeded",
+			retry:          retryPolicy{maxRetries: 3, initialInterval: time.Second, maxElapsedTime: time.Millisecond},
+			failures:       1,
+			failureStatus:  http.StatusServiceUnavailable,
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantAttempts:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != proxyGitlabTestBody {
+					t.Errorf("upstream received body %v, want %v", string(body), proxyGitlabTestBody)
+				}
+				if atomic.AddInt32(&attempts, 1) <= tt.failures {
+					w.WriteHeader(tt.failureStatus)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer upstream.Close()
+
+			p := &Proxy{retry: tt.retry}
+			hook := createGitlabHook(proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost)
+			resp, err := p.redirect(hook, upstream.URL+"/post")
+			if err != nil {
+				t.Fatalf("Proxy.redirect() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatusCode {
+				t.Errorf("Proxy.redirect() got StatusCode = %v, want %v", resp.StatusCode, tt.wantStatusCode)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("Proxy.redirect() made %v attempts, want %v", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+		wantOk bool
+	}{
+		{
+			name:   "TestRetryAfterWithSeconds",
+			header: "3",
+			want:   3 * time.Second,
+			wantOk: true,
+		},
+		{
+			name:   "TestRetryAfterWithoutHeader",
+			header: "",
+			wantOk: false,
+		},
+		{
+			name:   "TestRetryAfterWithInvalidValue",
+			header: "soon",
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			got, ok := retryAfter(resp)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		r         retryPolicy
+		intervals []time.Duration
+	}{
+		{
+			name:      "TestBackoffWithMaxInterval",
+			r:         retryPolicy{initialInterval: 100 * time.Millisecond, maxInterval: time.Second},
+			intervals: []time.Duration{100, 200, 400, 800, 1000},
+		},
+		{
+			name:      "TestBackoffWithoutMaxInterval",
+			r:         retryPolicy{initialInterval: 100 * time.Millisecond},
+			intervals: []time.Duration{100, 200, 400, 800, 1600},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for retry, interval := range tt.intervals {
+				interval *= time.Millisecond
+				if got := tt.r.backoff(retry); got < interval/2 || got > interval*3/2 {
+					t.Errorf("retryPolicy.backoff(%v) = %v, want between %v and %v", retry, got, interval/2, interval*3/2)
+				}
+			}
+			if got := tt.r.backoff(100); got <= 0 {
+				t.Errorf("retryPolicy.backoff(100) = %v, want a positive interval", got)
+			}
+		})
+	}
+}
+
+func TestNewProxyWithNegativeRetryIntervals(t *testing.T) {
+	for _, option := range []Option{
+		WithRetries(1, -time.Second, time.Second, time.Minute),
+		WithRetries(1, time.Second, -time.Second, time.Minute),
+		WithRetries(1, time.Second, time.Second, -time.Minute),
+	} {
+		if _, err := NewProxy("http://localhost", []string{}, providers.GitlabProviderKind, "", []string{}, option); err == nil {
+			t.Errorf("NewProxy() with negative retry interval error = nil, want an error")
+		}
+	}
+}
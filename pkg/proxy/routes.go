@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// HookInfo holds the provider independent fields of a hook used to route it.
+// Templated upstream URLs can reference these fields, e.g. {{.Repository}}.
+type HookInfo struct {
+	Provider   string
+	Path       string
+	Event      string
+	Repository string
+	Ref        string
+}
+
+// PathRewrite replaces the incoming path matching Pattern with Replacement,
+// which can reference capture groups of Pattern, e.g. $1 or ${name}
+type PathRewrite struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+
+	regex *regexp.Regexp
+}
+
+// Route selects the upstream for hooks matching all of its non-empty
+// conditions. Repositories and Refs are glob patterns.
+type Route struct {
+	Name         string   `json:"name"`
+	Paths        []string `json:"paths"`
+	Providers    []string `json:"providers"`
+	Events       []string `json:"events"`
+	Repositories []string `json:"repositories"`
+	Refs         []string `json:"refs"`
+	// Upstream is the URL to which the incoming path is appended. If it is a
+	// Go template it is rendered with HookInfo into the complete URL instead.
+	Upstream string `json:"upstream"`
+	// Upstreams are several URLs to which the hook is delivered concurrently,
+	// the first one is the primary upstream
+	Upstreams []string `json:"upstreams"`
+	// Policy decides the result of delivering to several upstreams: all, any or primary
+	Policy string `json:"policy"`
+	// Rewrites are applied to the incoming path, the first matching rewrite wins
+	Rewrites []PathRewrite `json:"rewrites"`
+	// Priority of the route's deliveries in async mode: high, normal or low
+	Priority string `json:"priority"`
+
+	templates []*template.Template
+}
+
+// targets returns the upstream URLs of the route
+func (r *Route) targets() []string {
+	if len(r.Upstreams) > 0 {
+		return r.Upstreams
+	}
+	return []string{r.Upstream}
+}
+
+func isTemplate(upstream string) bool {
+	return strings.Contains(upstream, "{{")
+}
+
+func (r *Route) compile() error {
+	if len(strings.TrimSpace(r.Upstream)) == 0 && len(r.Upstreams) == 0 {
+		return errors.New("Route '" + r.Name + "' has no upstream")
+	}
+	if len(strings.TrimSpace(r.Upstream)) > 0 && len(r.Upstreams) > 0 {
+		return errors.New("Route '" + r.Name + "' has both upstream and upstreams")
+	}
+	for _, upstream := range r.Upstreams {
+		if len(strings.TrimSpace(upstream)) == 0 {
+			return errors.New("Route '" + r.Name + "' has an empty upstream")
+		}
+	}
+
+	switch r.Policy {
+	case "", FanOutPolicyAll, FanOutPolicyAny, FanOutPolicyPrimary:
+	default:
+		return errors.New("Route '" + r.Name + "' has unknown policy '" + r.Policy + "'")
+	}
+
+	if err := validatePriority(r.Priority); err != nil {
+		return errors.New("Route '" + r.Name + "' has " + err.Error())
+	}
+
+	if err := validatePaths(r.Paths); err != nil {
+		return errors.New("Route '" + r.Name + "' has invalid paths: " + err.Error())
+	}
+
+	for _, patterns := range [][]string{r.Repositories, r.Refs} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+				return errors.New("Route '" + r.Name + "' has invalid pattern '" + pattern + "'")
+			}
+		}
+	}
+
+	for i := range r.Rewrites {
+		regex, err := regexp.Compile(r.Rewrites[i].Pattern)
+		if err != nil {
+			return errors.New("Route '" + r.Name + "' has invalid rewrite pattern: " + err.Error())
+		}
+		r.Rewrites[i].regex = regex
+	}
+
+	r.templates = make([]*template.Template, len(r.targets()))
+	for i, upstream := range r.targets() {
+		if !isTemplate(upstream) {
+			continue
+		}
+		tmpl, err := template.New(r.Name).Option("missingkey=error").Parse(upstream)
+		if err != nil {
+			return errors.New("Route '" + r.Name + "' has invalid upstream template: " + err.Error())
+		}
+		r.templates[i] = tmpl
+	}
+	return nil
+}
+
+// isTemplated checks whether any upstream of the route is a template
+func (r *Route) isTemplated() bool {
+	for _, tmpl := range r.templates {
+		if tmpl != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// rewritePath applies the first matching rewrite to the path
+func (r *Route) rewritePath(path string) string {
+	for _, rewrite := range r.Rewrites {
+		if rewrite.regex != nil && rewrite.regex.MatchString(path) {
+			return rewrite.regex.ReplaceAllString(path, rewrite.Replacement)
+		}
+	}
+	return path
+}
+
+// upstreamURLs returns the URLs to which the hook is proxied. info.Path is
+// appended to plain upstreams. Templated upstreams are rendered with info and
+// their host must match one of allowedHosts.
+func (r *Route) upstreamURLs(info HookInfo, allowedHosts []string) ([]string, error) {
+	targets := r.targets()
+	upstreamURLs := make([]string, len(targets))
+	for i, upstream := range targets {
+		if i >= len(r.templates) || r.templates[i] == nil {
+			upstreamURLs[i] = upstream + info.Path
+			continue
+		}
+
+		var rendered bytes.Buffer
+		if err := r.templates[i].Execute(&rendered, escapedHookInfo(info)); err != nil {
+			return nil, err
+		}
+		if !isAllowedHost(allowedHosts, rendered.String()) {
+			return nil, errors.New("Upstream host of '" + rendered.String() + "' is not allowed")
+		}
+		upstreamURLs[i] = rendered.String()
+	}
+	return upstreamURLs, nil
+}
+
+// escapedHookInfo path-escapes the fields of info, which come from the payload,
+// so that they can't add a query, a fragment or parent segments to a rendered
+// URL. Slashes are kept, e.g. for the namespace of a repository.
+func escapedHookInfo(info HookInfo) HookInfo {
+	return HookInfo{
+		Provider:   escapePath(info.Provider),
+		Path:       escapePath(info.Path),
+		Event:      escapePath(info.Event),
+		Repository: escapePath(info.Repository),
+		Ref:        escapePath(info.Ref),
+	}
+}
+
+// escapePath escapes each segment of value, including dot segments
+func escapePath(value string) string {
+	segments := strings.Split(value, "/")
+	for i, segment := range segments {
+		if segment == "." || segment == ".." {
+			segments[i] = strings.Repeat("%2E", len(segment))
+			continue
+		}
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// isAllowedHost checks the host of rawURL against glob patterns, e.g. *.jenkins.svc
+func isAllowedHost(allowedHosts []string, rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" || parsed.User != nil {
+		return false
+	}
+
+	for _, pattern := range allowedHosts {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if matched, _ := path.Match(pattern, strings.ToLower(parsed.Hostname())); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny checks value against a list of glob patterns ignoring case. An
+// empty list matches every value.
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if matched, _ := path.Match(pattern, strings.ToLower(value)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Route) matches(info HookInfo) bool {
+	return (len(r.Paths) == 0 || matchesPath(r.Paths, info.Path)) &&
+		matchesAny(r.Providers, info.Provider) &&
+		matchesAny(r.Events, info.Event) &&
+		matchesAny(r.Repositories, info.Repository) &&
+		matchesAny(r.Refs, info.Ref)
+}
+
+// routeFor returns the first route matching the hook or a route to the
+// default upstreamURL
+func (p *Proxy) routeFor(info HookInfo) *Route {
+	for i := range p.routes {
+		if p.routes[i].matches(info) {
+			return &p.routes[i]
+		}
+	}
+	return &Route{Name: "default", Upstream: p.upstreamURL}
+}
+
+// appendQuery appends the raw query of the incoming request to an upstream URL
+func appendQuery(upstreamURL string, rawQuery string) string {
+	if rawQuery == "" {
+		return upstreamURL
+	}
+	if strings.Contains(upstreamURL, "?") {
+		return upstreamURL + "&" + rawQuery
+	}
+	return upstreamURL + "?" + rawQuery
+}
@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestProxy_routeFor(t *testing.T) {
+	routes := []Route{
+		{
+			Name:     "tekton-releases",
+			Events:   []string{"push"},
+			Refs:     []string{"refs/tags/*"},
+			Upstream: "https://tekton.example.com",
+		},
+		{
+			Name:         "argo-platform",
+			Repositories: []string{"platform/*"},
+			Upstream:     "https://argo.example.com",
+		},
+		{
+			Name:      "gitlab-jenkins",
+			Paths:     []string{"/project"},
+			Providers: []string{providers.GitlabProviderKind},
+			Upstream:  "https://gitlab-jenkins.example.com",
+		},
+		{
+			Name:      "notify-deployments",
+			Events:    []string{"deployment"},
+			Upstreams: []string{"https://jenkins.example.com", "https://notifier.example.com"},
+			Policy:    FanOutPolicyPrimary,
+		},
+	}
+	for i := range routes {
+		if err := routes[i].compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		info       HookInfo
+		want       []string
+		wantPolicy string
+	}{
+		{
+			name: "TestRouteForTagPush",
+			info: HookInfo{Provider: "github", Path: "/github-webhook/", Event: "push",
+				Repository: "platform/jenkins", Ref: "refs/tags/v1.0.0"},
+			want: []string{"https://tekton.example.com"},
+		},
+		{
+			name: "TestRouteForBranchPushToPlatformRepository",
+			info: HookInfo{Provider: "github", Path: "/github-webhook/", Event: "push",
+				Repository: "platform/jenkins", Ref: "refs/heads/master"},
+			want: []string{"https://argo.example.com"},
+		},
+		{
+			name: "TestRouteForGitlabPath",
+			info: HookInfo{Provider: "gitlab", Path: "/project/app", Event: "Push Hook",
+				Repository: "mike/diaspora", Ref: "refs/heads/master"},
+			want: []string{"https://gitlab-jenkins.example.com"},
+		},
+		{
+			name: "TestRouteForDefault",
+			info: HookInfo{Provider: "github", Path: "/github-webhook/", Event: "pull_request",
+				Repository: "stakater/GitWebhookProxy", Ref: "refs/heads/master"},
+			want: []string{"https://jenkins.example.com"},
+		},
+		{
+			name: "TestRouteForFanOut",
+			info: HookInfo{Provider: "github", Path: "/github-webhook/", Event: "deployment",
+				Repository: "stakater/GitWebhookProxy"},
+			want:       []string{"https://jenkins.example.com", "https://notifier.example.com"},
+			wantPolicy: FanOutPolicyPrimary,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{
+				upstreamURL: "https://jenkins.example.com",
+				routes:      routes,
+			}
+			route := p.routeFor(tt.info)
+			if got := route.targets(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Proxy.routeFor() upstreams = %v, want %v", got, tt.want)
+			}
+			if route.Policy != tt.wantPolicy {
+				t.Errorf("Proxy.routeFor() policy = %v, want %v", route.Policy, tt.wantPolicy)
+			}
+		})
+	}
+}
+
+func TestRoute_compile(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   Route
+		wantErr bool
+	}{
+		{
+			name:  "TestCompileWithValidRoute",
+			route: Route{Name: "valid", Refs: []string{"refs/heads/release-*"}, Upstream: "https://jenkins.example.com"},
+		},
+		{
+			name:    "TestCompileWithoutUpstream",
+			route:   Route{Name: "no-upstream"},
+			wantErr: true,
+		},
+		{
+			name:    "TestCompileWithUpstreamAndUpstreams",
+			route:   Route{Name: "both", Upstream: "https://a.example.com", Upstreams: []string{"https://b.example.com"}},
+			wantErr: true,
+		},
+		{
+			name:    "TestCompileWithUnknownPolicy",
+			route:   Route{Name: "policy", Upstreams: []string{"https://a.example.com"}, Policy: "some"},
+			wantErr: true,
+		},
+		{
+			name:    "TestCompileWithUnknownPriority",
+			route:   Route{Name: "priority", Upstream: "https://a.example.com", Priority: "urgent"},
+			wantErr: true,
+		},
+		{
+			name:    "TestCompileWithInvalidRewrite",
+			route:   Route{Name: "rewrite", Upstream: "https://a.example.com", Rewrites: []PathRewrite{{Pattern: "(", Replacement: "/"}}},
+			wantErr: true,
+		},
+		{
+			name:    "TestCompileWithInvalidTemplate",
+			route:   Route{Name: "template", Upstream: "https://a.example.com/job/{{.Repository"},
+			wantErr: true,
+		},
+		{
+			name:    "TestCompileWithInvalidPattern",
+			route:   Route{Name: "invalid", Repositories: []string{"platform/["}, Upstream: "https://jenkins.example.com"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.route.compile(); (err != nil) != tt.wantErr {
+				t.Errorf("Route.compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoute_rewritePath(t *testing.T) {
+	route := Route{
+		Name:     "jenkins",
+		Upstream: "https://jenkins.example.com",
+		Rewrites: []PathRewrite{
+			{Pattern: `^/github-webhook/?$`, Replacement: "/generic-webhook-trigger/invoke"},
+			{Pattern: `^/hooks/(?P<job>[\w-]+)$`, Replacement: "/job/${job}/build"},
+		},
+	}
+	if err := route.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{
+			name: "TestRewritePathWithStaticRewrite",
+			path: "/github-webhook/",
+			want: "/generic-webhook-trigger/invoke",
+		},
+		{
+			name: "TestRewritePathWithCaptureGroup",
+			path: "/hooks/app-build",
+			want: "/job/app-build/build",
+		},
+		{
+			name: "TestRewritePathWithoutMatch",
+			path: "/project/app",
+			want: "/project/app",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := route.rewritePath(tt.path); got != tt.want {
+				t.Errorf("Route.rewritePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoute_upstreamURLs(t *testing.T) {
+	info := HookInfo{
+		Provider:   "github",
+		Path:       "/github-webhook/",
+		Event:      "push",
+		Repository: "platform/jenkins",
+		Ref:        "refs/heads/master",
+	}
+	tests := []struct {
+		name         string
+		info         HookInfo
+		upstreams    []string
+		allowedHosts []string
+		want         []string
+		wantErr      bool
+	}{
+		{
+			name:      "TestUpstreamURLsWithPlainUpstream",
+			upstreams: []string{"https://jenkins.example.com"},
+			want:      []string{"https://jenkins.example.com/github-webhook/"},
+		},
+		{
+			name:         "TestUpstreamURLsWithTemplate",
+			upstreams:    []string{"https://jenkins.example.com/job/{{.Repository}}/{{.Event}}", "https://notifier.example.com"},
+			allowedHosts: []string{"*.example.com"},
+			want: []string{"https://jenkins.example.com/job/platform/jenkins/push",
+				"https://notifier.example.com/github-webhook/"},
+		},
+		{
+			name:         "TestUpstreamURLsWithTemplateAndHostileRepository",
+			info:         HookInfo{Event: "push", Repository: "platform/../../admin?token=secret#build"},
+			upstreams:    []string{"https://jenkins.example.com/job/{{.Repository}}/{{.Event}}"},
+			allowedHosts: []string{"*.example.com"},
+			want:         []string{"https://jenkins.example.com/job/platform/%2E%2E/%2E%2E/admin%3Ftoken=secret%23build/push"},
+		},
+		{
+			name:         "TestUpstreamURLsWithTemplatedHostNotAllowed",
+			upstreams:    []string{"https://{{.Repository}}.example.com/build"},
+			allowedHosts: []string{"jenkins.example.com"},
+			wantErr:      true,
+		},
+		{
+			name:         "TestUpstreamURLsWithTemplatedUserInfo",
+			upstreams:    []string{"https://{{.Event}}@jenkins.example.com/build"},
+			allowedHosts: []string{"jenkins.example.com"},
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := Route{Name: tt.name, Upstreams: tt.upstreams}
+			if err := route.compile(); err != nil {
+				t.Fatal(err)
+			}
+			hookInfo := info
+			if tt.info != (HookInfo{}) {
+				hookInfo = tt.info
+			}
+			got, err := route.upstreamURLs(hookInfo, tt.allowedHosts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Route.upstreamURLs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Route.upstreamURLs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
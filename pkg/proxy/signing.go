@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+// UpstreamSecret is the secret with which hooks are signed again before they
+// are sent to the upstream hosts matching Hosts, or to all upstreams if Hosts
+// is empty
+type UpstreamSecret struct {
+	// Hosts are glob patterns of upstream hosts, e.g. *.jenkins.svc
+	Hosts  []string `json:"hosts"`
+	Secret string   `json:"secret"`
+}
+
+// upstreamSecretFor returns the secret of the first upstream secret matching
+// the host of redirectURL
+func (p *Proxy) upstreamSecretFor(redirectURL string) (string, bool) {
+	host := upstreamHost(redirectURL)
+	for _, upstreamSecret := range p.upstreamSecrets {
+		if len(upstreamSecret.Hosts) == 0 || matchesHost(upstreamSecret.Hosts, host) {
+			return upstreamSecret.Secret, true
+		}
+	}
+	return "", false
+}
+
+// headersFor returns the headers of the hook sent to redirectURL, with the
+// provider's signature replaced if the upstream has its own secret. The hook
+// itself is not changed as it may be sent to several upstreams concurrently.
+func (p *Proxy) headersFor(hook *providers.Hook, redirectURL string) (map[string]string, error) {
+	secret, ok := p.upstreamSecretFor(redirectURL)
+	if !ok {
+		return hook.Headers, nil
+	}
+
+	provider, err := providers.NewProvider(p.provider, p.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := &providers.Hook{
+		Payload:       hook.Payload,
+		Headers:       make(map[string]string, len(hook.Headers)+1),
+		RequestMethod: hook.RequestMethod,
+	}
+	for key, value := range hook.Headers {
+		signed.Headers[key] = value
+	}
+	provider.Sign(signed, secret)
+	return signed.Headers, nil
+}
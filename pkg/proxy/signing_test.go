@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func TestProxy_redirectOnceWithUpstreamSecrets(t *testing.T) {
+	const upstreamSecret = "upstreamSecret"
+	var signature, signature256, token string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(providers.XHubSignature)
+		signature256 = r.Header.Get(providers.XHubSignature256)
+		token = r.Header.Get(providers.XGitlabToken)
+		if providers.IsValidPayload(upstreamSecret, strings.TrimPrefix(signature, providers.SignaturePrefix), body) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	githubHook := &providers.Hook{
+		Headers: map[string]string{
+			providers.XHubSignature: providers.SignaturePrefix + providers.HashPayload("proxySecret", []byte(proxyGitlabTestBody)),
+			providers.XGitHubEvent:  "push",
+		},
+		Payload:       []byte(proxyGitlabTestBody),
+		RequestMethod: http.MethodPost,
+	}
+
+	tests := []struct {
+		name            string
+		provider        string
+		hook            *providers.Hook
+		upstreamSecrets []UpstreamSecret
+		wantStatus      int
+		wantToken       string
+	}{
+		{
+			name:       "TestRedirectOnceWithoutUpstreamSecrets",
+			provider:   providers.GithubProviderKind,
+			hook:       githubHook,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:            "TestRedirectOnceWithUpstreamSecretOfOtherHost",
+			provider:        providers.GithubProviderKind,
+			hook:            githubHook,
+			upstreamSecrets: []UpstreamSecret{{Hosts: []string{"jenkins.example.com"}, Secret: upstreamSecret}},
+			wantStatus:      http.StatusUnauthorized,
+		},
+		{
+			name:            "TestRedirectOnceWithGithubUpstreamSecret",
+			provider:        providers.GithubProviderKind,
+			hook:            githubHook,
+			upstreamSecrets: []UpstreamSecret{{Hosts: []string{"127.0.0.*"}, Secret: upstreamSecret}},
+			wantStatus:      http.StatusOK,
+		},
+		{
+			name:            "TestRedirectOnceWithGitlabUpstreamSecret",
+			provider:        providers.GitlabProviderKind,
+			hook:            createGitlabHook(proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost),
+			upstreamSecrets: []UpstreamSecret{{Secret: upstreamSecret}},
+			wantStatus:      http.StatusUnauthorized,
+			wantToken:       upstreamSecret,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProxy(upstream.URL, []string{}, tt.provider, "proxySecret", []string{},
+				WithUpstreamSecrets(tt.upstreamSecrets))
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers := map[string]string{}
+			for key, value := range tt.hook.Headers {
+				headers[key] = value
+			}
+
+			resp, err := p.redirectOnce(tt.hook, upstream.URL+"/post")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Proxy.redirectOnce() status = %v, want %v", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.provider == providers.GithubProviderKind && tt.wantStatus == http.StatusOK &&
+				signature256 != providers.Signature256Prefix+providers.HashPayload256(upstreamSecret, tt.hook.Payload) {
+				t.Errorf("Proxy.redirectOnce() sent %v = %v, want it signed with the upstream secret",
+					providers.XHubSignature256, signature256)
+			}
+			if len(tt.wantToken) > 0 && token != tt.wantToken {
+				t.Errorf("Proxy.redirectOnce() sent %v = %v, want %v", providers.XGitlabToken, token, tt.wantToken)
+			}
+			for key, value := range headers {
+				if tt.hook.Headers[key] != value {
+					t.Errorf("Proxy.redirectOnce() changed the hook's %v header to %v", key, tt.hook.Headers[key])
+				}
+			}
+		})
+	}
+}
+
+func TestNewProxyWithInvalidUpstreamSecrets(t *testing.T) {
+	for _, upstreamSecret := range []UpstreamSecret{
+		{Hosts: []string{"jenkins.example.com"}},
+		{Hosts: []string{"["}, Secret: "secret"},
+	} {
+		if _, err := NewProxy("http://localhost", []string{}, providers.GitlabProviderKind, "", []string{},
+			WithUpstreamSecrets([]UpstreamSecret{upstreamSecret})); err == nil {
+			t.Errorf("NewProxy() with upstream secret %+v error = nil, want an error", upstreamSecret)
+		}
+	}
+}
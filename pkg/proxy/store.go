@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+const deliveryFileExtension = ".json"
+
+// credentialHeaders carry credentials of the Git provider's request, which a
+// header policy forwarding all headers passes to the upstream
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// deliveryStore persists deliveries as one JSON file each in a directory, so
+// they survive a restart of the Proxy
+type deliveryStore struct {
+	dir string
+	// omittedHeaders are not written to disk in addition to the signature and
+	// credential headers, e.g. the static headers of the header policy
+	omittedHeaders []string
+}
+
+func newDeliveryStore(dir string, omittedHeaders []string) (*deliveryStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &deliveryStore{dir: dir, omittedHeaders: omittedHeaders}, nil
+}
+
+func (s *deliveryStore) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+deliveryFileExtension)
+}
+
+// save writes the delivery without its secret headers to disk and syncs it
+// before returning, the file is written under a temporary name first so a
+// crash never leaves a partial delivery
+func (s *deliveryStore) save(delivery *Delivery) error {
+	data, err := json.Marshal(s.stripped(delivery))
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), s.path(delivery.ID))
+}
+
+func (s *deliveryStore) remove(id string) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *deliveryStore) load(id string) (*Delivery, error) {
+	data, err := ioutil.ReadFile(s.path(id))
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &Delivery{}
+	if err := json.Unmarshal(data, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// list returns all stored deliveries in the order they were accepted
+func (s *deliveryStore) list() ([]*Delivery, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []*Delivery{}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, deliveryFileExtension) {
+			continue
+		}
+		delivery, err := s.load(strings.TrimSuffix(name, deliveryFileExtension))
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].AcceptedAt.Before(deliveries[j].AcceptedAt)
+	})
+	return deliveries, nil
+}
+
+// stripped returns a copy of the delivery without the hook's signature,
+// credential and omitted headers, which carry secrets and must not be written
+// to disk
+func (s *deliveryStore) stripped(delivery *Delivery) *Delivery {
+	if delivery.Hook == nil {
+		return delivery
+	}
+
+	hook := *delivery.Hook
+	hook.Headers = make(map[string]string, len(delivery.Hook.Headers))
+	for name, value := range delivery.Hook.Headers {
+		if !matchesHeader(providers.SignatureHeaders, name) && !matchesHeader(credentialHeaders, name) &&
+			!matchesHeader(s.omittedHeaders, name) {
+			hook.Headers[name] = value
+		}
+	}
+	copied := *delivery
+	copied.Hook = &hook
+	return &copied
+}
+
+// restoreHeaders sets the static headers of the header policy and the
+// signature headers of a delivery loaded from disk again before it is
+// delivered. Without a secret the delivery is sent unsigned.
+func (p *Proxy) restoreHeaders(delivery *Delivery) {
+	if delivery.Hook == nil {
+		return
+	}
+	if delivery.Hook.Headers == nil {
+		delivery.Hook.Headers = map[string]string{}
+	}
+	for name, value := range p.headerPolicy.Add {
+		setHeader(delivery.Hook.Headers, name, value)
+	}
+	if len(strings.TrimSpace(p.secret)) == 0 {
+		return
+	}
+	provider, err := providers.NewProvider(p.provider, p.secret)
+	if err != nil {
+		log.Printf("Error signing '%s': %s\n", delivery.ID, err)
+		return
+	}
+	provider.Sign(delivery.Hook, p.secret)
+}
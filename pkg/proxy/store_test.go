@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func createTestDelivery(id string, acceptedAt time.Time, redirectURL string) *Delivery {
+	return &Delivery{
+		ID:           id,
+		Hook:         createGitlabHook(proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost),
+		RedirectURLs: []string{redirectURL},
+		Source:       "/post",
+		AcceptedAt:   acceptedAt,
+	}
+}
+
+func TestDeliveryStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwp-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := newDeliveryStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	second := createTestDelivery("second", now, "http://localhost/post")
+	first := createTestDelivery("first", now.Add(-time.Minute), "http://localhost/post")
+	for _, delivery := range []*Delivery{second, first} {
+		if err := store.save(delivery); err != nil {
+			t.Fatalf("deliveryStore.save() error = %v", err)
+		}
+	}
+
+	deliveries, err := store.list()
+	if err != nil {
+		t.Fatalf("deliveryStore.list() error = %v", err)
+	}
+	// The webhook secret is never written to disk
+	if want := []*Delivery{store.stripped(first), store.stripped(second)}; !reflect.DeepEqual(deliveries, want) {
+		t.Errorf("deliveryStore.list() = %v, want %v", deliveries, want)
+	}
+	if token, ok := deliveries[0].Hook.Headers[providers.XGitlabToken]; ok {
+		t.Errorf("deliveryStore.list() returned %v header %v", providers.XGitlabToken, token)
+	}
+
+	if err := store.remove("first"); err != nil {
+		t.Fatalf("deliveryStore.remove() error = %v", err)
+	}
+	if err := store.remove("first"); err != nil {
+		t.Errorf("deliveryStore.remove() of removed delivery error = %v", err)
+	}
+	if deliveries, _ := store.list(); len(deliveries) != 1 || deliveries[0].ID != "second" {
+		t.Errorf("deliveryStore.list() after remove = %v, want only second", deliveries)
+	}
+}
+
+func TestProxy_resumePending(t *testing.T) {
+	received := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		// The stripped token is set again from the proxy's secret
+		if r.Header.Get(providers.XGitlabToken) != proxyGitlabTestSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			received <- "unsigned"
+			return
+		}
+		received <- string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	dir, err := ioutil.TempDir("", "gwp-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, _ := newDeliveryStore(dir, nil)
+	if err := store.save(createTestDelivery("pending", time.Now(), upstream.URL+"/post")); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, proxyGitlabTestSecret, []string{},
+		WithAsyncDelivery(1, 1), WithQueueDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case body := <-received:
+		if body != proxyGitlabTestBody {
+			t.Errorf("upstream received body %v, want %v", body, proxyGitlabTestBody)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("upstream did not receive the pending delivery")
+	}
+
+	for i := 0; i < 50; i++ {
+		if deliveries, _ := p.store.list(); len(deliveries) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("delivered request was not removed from the queue directory")
+}
+
+func TestNewProxyWithPendingDeliveriesAndInvalidOptions(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	dir, err := ioutil.TempDir("", "gwp-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, _ := newDeliveryStore(dir, nil)
+	if err := store.save(createTestDelivery("pending", time.Now(), upstream.URL+"/post")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, proxyGitlabTestSecret, []string{},
+		WithAsyncDelivery(1, 1), WithQueueDir(dir), WithForkPolicy("unknown", nil, "")); err == nil {
+		t.Fatalf("NewProxy() with unknown fork policy error = nil, want an error")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&requests); got != 0 {
+		t.Errorf("invalid Proxy delivered %d pending deliveries, want none", got)
+	}
+}
+
+func TestProxy_resumePendingWithHeaderPolicy(t *testing.T) {
+	received := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	dir, err := ioutil.TempDir("", "gwp-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, _ := newDeliveryStore(dir, []string{"X-Upstream-Key"})
+	delivery := createTestDelivery("pending", time.Now(), upstream.URL+"/post")
+	delivery.Hook.Headers["Authorization"] = "Bearer forwarded"
+	delivery.Hook.Headers["x-upstream-key"] = "static"
+	if err := store.save(delivery); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(store.path("pending"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"forwarded", "static", proxyGitlabTestSecret} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("stored delivery %s contains %v", data, secret)
+		}
+	}
+
+	_, err = NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, proxyGitlabTestSecret, []string{},
+		WithAsyncDelivery(1, 1), WithQueueDir(dir),
+		WithHeaderPolicy(HeaderPolicy{Add: map[string]string{"X-Upstream-Key": "static"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case header := <-received:
+		// The static header is set again, the forwarded credentials are lost
+		if got := header.Get("X-Upstream-Key"); got != "static" {
+			t.Errorf("upstream received X-Upstream-Key = %v, want static", got)
+		}
+		if got := header.Get("Authorization"); got != "" {
+			t.Errorf("upstream received Authorization = %v, want none", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("upstream did not receive the pending delivery")
+	}
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// UpstreamTLS configures the TLS connections to the upstream hosts matching
+// Hosts, or to all upstreams if Hosts is empty
+type UpstreamTLS struct {
+	// Hosts are glob patterns of upstream hosts, e.g. *.jenkins.svc
+	Hosts []string `json:"hosts"`
+	// CAFile is a PEM bundle of CA certificates trusted in addition to the system ones
+	CAFile string `json:"caFile"`
+	// CertFile and KeyFile are the PEM client certificate and key for mutual TLS
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// InsecureSkipVerify disables the verification of the upstream's certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+// upstreamClient is the HTTP client used for the upstream hosts matching hosts
+type upstreamClient struct {
+	hosts  []string
+	client *http.Client
+}
+
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout: httpClient.Timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
+
+// tlsConfig loads the CA bundle and client certificate of the upstream TLS configuration
+func (u UpstreamTLS) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: u.InsecureSkipVerify}
+
+	if len(u.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(u.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in CA file '" + u.CAFile + "'")
+		}
+		config.RootCAs = pool
+	}
+
+	if len(u.CertFile) > 0 || len(u.KeyFile) > 0 {
+		if len(u.CertFile) == 0 || len(u.KeyFile) == 0 {
+			return nil, errors.New("client certificate and key must be configured together")
+		}
+		certificate, err := tls.LoadX509KeyPair(u.CertFile, u.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// clientFor returns the HTTP client of the first upstream TLS configuration
+// matching the host of redirectURL, or the default client verifying
+// certificates against the system CAs
+func (p *Proxy) clientFor(redirectURL string) *http.Client {
+	host := upstreamHost(redirectURL)
+	for _, upstream := range p.upstreamClients {
+		if len(upstream.hosts) == 0 || matchesHost(upstream.hosts, host) {
+			return upstream.client
+		}
+	}
+	return httpClient
+}
+
+// upstreamHost returns the lower case host name of redirectURL
+func upstreamHost(redirectURL string) string {
+	parsed, err := url.Parse(upstreamKey(redirectURL))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// matchesHost checks host against glob patterns, e.g. *.jenkins.svc
+func matchesHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(strings.TrimSpace(pattern)), host); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stakater/GitWebhookProxy/pkg/providers"
+)
+
+func writePEM(t *testing.T, path string, blockType string, bytes []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeClientCertificate writes a self-signed client certificate and its key to dir
+func writeClientCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gitwebhookproxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writePEM(t, certFile, "CERTIFICATE", certificate)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyBytes)
+	return certFile, keyFile
+}
+
+func TestProxy_redirectWithUpstreamTLS(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	dir, err := ioutil.TempDir("", "gwp-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", upstream.Certificate().Raw)
+	certFile, keyFile := writeClientCertificate(t, dir)
+
+	tests := []struct {
+		name        string
+		upstreamTLS []UpstreamTLS
+		wantErr     bool
+		wantStatus  int
+	}{
+		{
+			name:    "TestRedirectWithUnknownCA",
+			wantErr: true,
+		},
+		{
+			name:        "TestRedirectWithUnknownCAOfOtherHost",
+			upstreamTLS: []UpstreamTLS{{Hosts: []string{"jenkins.example.com"}, InsecureSkipVerify: true}},
+			wantErr:     true,
+		},
+		{
+			name:        "TestRedirectWithInsecureSkipVerify",
+			upstreamTLS: []UpstreamTLS{{Hosts: []string{"127.0.0.*"}, InsecureSkipVerify: true}},
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "TestRedirectWithCAFile",
+			upstreamTLS: []UpstreamTLS{{CAFile: caFile}},
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "TestRedirectWithClientCertificate",
+			upstreamTLS: []UpstreamTLS{{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}},
+			wantStatus:  http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProxy(upstream.URL, []string{}, providers.GitlabProviderKind, "", []string{},
+				WithUpstreamTLS(tt.upstreamTLS))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hook := createGitlabHook(proxyGitlabTestSecret, proxyGitlabTestEvent, proxyGitlabTestBody, http.MethodPost)
+			resp, err := p.redirectOnce(hook, upstream.URL+"/post")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Proxy.redirectOnce() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Proxy.redirectOnce() status = %v, want %v", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNewProxyWithInvalidUpstreamTLS(t *testing.T) {
+	for _, upstreamTLS := range []UpstreamTLS{
+		{CAFile: "/does/not/exist.pem"},
+		{CertFile: "/etc/gitwebhookproxy/tls.crt"},
+		{Hosts: []string{"["}},
+	} {
+		if _, err := NewProxy("http://localhost", []string{}, providers.GitlabProviderKind, "", []string{},
+			WithUpstreamTLS([]UpstreamTLS{upstreamTLS})); err == nil {
+			t.Errorf("NewProxy() with upstream TLS %+v error = nil, want an error", upstreamTLS)
+		}
+	}
+}